@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// lineOverhead - примерно сколько памяти кроме самой строки занимает sortItem, keyOverhead - каждый его ключ
+const (
+	lineOverhead = 48
+	keyOverhead  = 24
+)
+
+// maxMergeRuns - сколько кусков сливаем за раз, чтобы не упереться в лимит открытых файлов
+const maxMergeRuns = 64
+
+var errTempStorageClosed = errors.New("temporary storage is already removed")
+
+// tempStorage - каталог временных файлов, создаётся при первом куске
+//
+// cleanup можно звать из обработчика сигнала параллельно с сортировкой, после него новые файлы не создаются
+type tempStorage struct {
+	mu     sync.Mutex
+	parent string
+	dir    string
+	files  []*os.File
+	closed bool
+}
+
+func newTempStorage(parent string) *tempStorage {
+	return &tempStorage{parent: parent}
+}
+
+// create создаёт новый временный файл
+func (t *tempStorage) create() (*os.File, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, errTempStorageClosed
+	}
+	if t.dir == "" {
+		dir, err := os.MkdirTemp(t.parent, "sort-")
+		if err != nil {
+			return nil, fmt.Errorf("error creating temporary directory: %w", err)
+		}
+		t.dir = dir
+	}
+
+	file, err := os.CreateTemp(t.dir, "run-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	t.files = append(t.files, file)
+	return file, nil
+}
+
+// cleanup закрывает и удаляет все временные файлы
+func (t *tempStorage) cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for _, file := range t.files {
+		_ = file.Close()
+	}
+	t.files = nil
+	if t.dir != "" {
+		_ = os.RemoveAll(t.dir)
+	}
+}
+
+// writeRun пишет сортированный кусок во временный файл, по строке на значение
+func (t *tempStorage) writeRun(items []sortItem, options sortOptions) (runReader, error) {
+	file, err := t.create()
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, item := range items {
+		writer.WriteString(item.line)
+		writer.WriteByte('\n')
+	}
+	if err = writer.Flush(); err != nil {
+		return nil, fmt.Errorf("error writing temporary file: %w", err)
+	}
+	return newFileRun(file, options)
+}
+
+// runReader отдаёт строки сортированного куска по порядку
+type runReader interface {
+	// next возвращает следующую строку, ok = false когда кусок кончился
+	next() (item sortItem, ok bool, err error)
+	// close освобождает кусок после слияния
+	close()
+}
+
+// memoryRun - кусок, который остался в памяти
+type memoryRun struct {
+	items []sortItem
+}
+
+func (r *memoryRun) next() (sortItem, bool, error) {
+	if len(r.items) == 0 {
+		return sortItem{}, false, nil
+	}
+	item := r.items[0]
+	r.items = r.items[1:]
+	return item, true, nil
+}
+
+func (r *memoryRun) close() {
+	r.items = nil
+}
+
+// fileRun - кусок во временном файле, ключи разбираются заново при чтении
+type fileRun struct {
+	file    *os.File
+	reader  *bufio.Reader
+	options sortOptions
+}
+
+// newFileRun перематывает записанный файл в начало для чтения
+func newFileRun(file *os.File, options sortOptions) (*fileRun, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error rewinding temporary file: %w", err)
+	}
+	return &fileRun{file: file, reader: bufio.NewReader(file), options: options}, nil
+}
+
+func (r *fileRun) next() (sortItem, bool, error) {
+	line, err := r.reader.ReadString('\n')
+	if errors.Is(err, io.EOF) && line == "" {
+		return sortItem{}, false, nil
+	}
+	if err != nil {
+		return sortItem{}, false, fmt.Errorf("error reading temporary file: %w", err)
+	}
+
+	return makeItem(line[:len(line)-1], 0, r.options), true, nil
+}
+
+// close удаляет файл сразу, чтобы слитые куски не занимали диск до конца сортировки
+func (r *fileRun) close() {
+	_ = r.file.Close()
+	_ = os.Remove(r.file.Name())
+}
+
+// mergeRuns сливает куски в output, по пути убирая строки с равными ключами при -u
+//
+// кусков больше maxMergeRuns - сначала соседние сливаются группами в новые временные файлы
+func mergeRuns(output io.Writer, runs []runReader, options sortOptions, temp *tempStorage) error {
+	for len(runs) > maxMergeRuns {
+		merged := make([]runReader, 0, len(runs)/maxMergeRuns+1)
+		for start := 0; start < len(runs); start += maxMergeRuns {
+			group := runs[start:min(start+maxMergeRuns, len(runs))]
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+
+			file, err := temp.create()
+			if err != nil {
+				return err
+			}
+			writer := bufio.NewWriter(file)
+			if err = mergeInto(writer, group, options, false); err != nil {
+				return err
+			}
+			if err = writer.Flush(); err != nil {
+				return fmt.Errorf("error writing temporary file: %w", err)
+			}
+			run, err := newFileRun(file, options)
+			if err != nil {
+				return err
+			}
+			merged = append(merged, run)
+		}
+		runs = merged
+	}
+
+	writer := bufio.NewWriter(output)
+	if err := mergeInto(writer, runs, options, options.onlyUnique); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// mergeInto сливает куски через кучу, пустой результат - одна пустая строка, как у fmt.Println
+func mergeInto(writer *bufio.Writer, runs []runReader, options sortOptions, onlyUnique bool) error {
+	defer func() {
+		for _, run := range runs {
+			run.close()
+		}
+	}()
+
+	h := &mergeHeap{options: options}
+	for i, run := range runs {
+		item, ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.items = append(h.items, mergeItem{item: item, run: i})
+		}
+	}
+	heap.Init(h)
+
+	// при -u остаётся первая из строк с равными ключами
+	var previous sortItem
+	written := false
+	for h.Len() > 0 {
+		top := &h.items[0]
+		if !onlyUnique || !written || compareItems(previous, top.item, options) != 0 {
+			writer.WriteString(top.item.line)
+			writer.WriteByte('\n')
+			previous, written = top.item, true
+		}
+
+		item, ok, err := runs[top.run].next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			top.item = item
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	if !written {
+		writer.WriteByte('\n')
+	}
+	return nil
+}
+
+// mergeItem - текущая строка куска run
+type mergeItem struct {
+	item sortItem
+	run  int
+}
+
+// mergeHeap - куча текущих строк кусков, сверху та, что выводится следующей
+//
+// равные строки идут в том же порядке, что и внутри куска (см. sortItems): сначала из более раннего куска
+type mergeHeap struct {
+	items   []mergeItem
+	options sortOptions
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	if compareResult := compareItems(h.items[i].item, h.items[j].item, h.options); compareResult != 0 {
+		return compareResult < 0
+	}
+	return h.items[i].run < h.items[j].run
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergeHeap) Push(x any) {
+	h.items = append(h.items, x.(mergeItem))
+}
+
+func (h *mergeHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
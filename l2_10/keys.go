@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// keyDef - ключ сортировки из -k POS1[,POS2], позиции как в GNU sort: F[.C][OPTS]
+//
+// поля и символы считаются с 1, без POS2 ключ идёт до конца строки, C = 0 в POS2 - до конца поля
+type keyDef struct {
+	startField int
+	startChar  int
+	// endField = 0 - до конца строки
+	endField int
+	endChar  int
+	// skipStartBlanks, skipEndBlanks - флаг b у POS1 и POS2: пробелы в начале поля не считаются
+	skipStartBlanks bool
+	skipEndBlanks   bool
+
+	asNumber bool
+	asMonth  bool
+	asMemory bool
+	reverse  bool
+	// ownOrder - у ключа есть свои флаги, тогда глобальные -n, -M, -h, -r на него не действуют
+	ownOrder bool
+}
+
+// parseKeyDef разбирает -k: "2", "2,2", "2.3,2.5", "3,3nr", "1b,1"
+func parseKeyDef(spec string) (keyDef, error) {
+	startSpec, endSpec, hasEnd := strings.Cut(spec, ",")
+
+	def := keyDef{}
+	var flags string
+	var err error
+	def.startField, def.startChar, flags, err = parsePosition(startSpec, 1)
+	if err != nil {
+		return def, fmt.Errorf("invalid -k '%s': %w", spec, err)
+	}
+	if def.startChar == 0 {
+		return def, fmt.Errorf("invalid -k '%s': character offset is zero", spec)
+	}
+	if err = def.setFlags(flags, false); err != nil {
+		return def, fmt.Errorf("invalid -k '%s': %w", spec, err)
+	}
+
+	if hasEnd {
+		def.endField, def.endChar, flags, err = parsePosition(endSpec, 0)
+		if err != nil {
+			return def, fmt.Errorf("invalid -k '%s': %w", spec, err)
+		}
+		if err = def.setFlags(flags, true); err != nil {
+			return def, fmt.Errorf("invalid -k '%s': %w", spec, err)
+		}
+	}
+	return def, nil
+}
+
+// parsePosition разбирает F[.C][OPTS], defaultChar - C, если его нет
+func parsePosition(s string, defaultChar int) (field int, char int, flags string, err error) {
+	digits := countDigits(s)
+	if digits == 0 {
+		return 0, 0, "", fmt.Errorf("field number expected in '%s'", s)
+	}
+	field, err = strconv.Atoi(s[:digits])
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid field number '%s': %w", s[:digits], err)
+	}
+	if field == 0 {
+		return 0, 0, "", fmt.Errorf("field number is zero")
+	}
+	s = s[digits:]
+
+	char = defaultChar
+	if strings.HasPrefix(s, ".") {
+		s = s[1:]
+		digits = countDigits(s)
+		if digits == 0 {
+			return 0, 0, "", fmt.Errorf("character offset expected after '.'")
+		}
+		char, err = strconv.Atoi(s[:digits])
+		if err != nil {
+			return 0, 0, "", fmt.Errorf("invalid character offset '%s': %w", s[:digits], err)
+		}
+		s = s[digits:]
+	}
+	return field, char, s, nil
+}
+
+// countDigits - сколько цифр в начале s
+func countDigits(s string) int {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// setFlags ставит флаги ключа, b относится к той позиции, за которой написан
+func (d *keyDef) setFlags(flags string, end bool) error {
+	for _, flagRune := range flags {
+		switch flagRune {
+		case 'b':
+			if end {
+				d.skipEndBlanks = true
+			} else {
+				d.skipStartBlanks = true
+			}
+		case 'n':
+			d.asNumber = true
+		case 'M':
+			d.asMonth = true
+		case 'h':
+			d.asMemory = true
+		case 'r':
+			d.reverse = true
+		default:
+			return fmt.Errorf("unknown key flag '%c'", flagRune)
+		}
+		d.ownOrder = true
+	}
+	return nil
+}
+
+// resolveKeys возвращает ключи, по которым на самом деле идёт сравнение
+//
+// без -k ключ один - вся строка, ключи без своих флагов берут глобальные -n, -M, -h, -r
+func resolveKeys(options sortOptions) []keyDef {
+	keys := options.keys
+	if len(keys) == 0 {
+		keys = []keyDef{{startField: 1, startChar: 1}}
+	}
+
+	resolved := make([]keyDef, len(keys))
+	for i, def := range keys {
+		if !def.ownOrder {
+			def.asNumber = options.asNumber
+			def.asMonth = options.asMonth
+			def.asMemory = options.asMemory
+			def.reverse = options.reverse
+			def.ownOrder = true
+		}
+		resolved[i] = def
+	}
+	return resolved
+}
+
+// extractKey вырезает из строки часть, которую описывает ключ, если ключ кончается раньше начала - пустую
+func extractKey(line string, def keyDef, separator rune) string {
+	start := keyPosition(line, def.startField, def.startChar-1, def.skipStartBlanks, separator)
+
+	end := len(line)
+	if def.endField > 0 {
+		if def.endChar == 0 {
+			_, end = fieldBounds(line, def.endField, separator)
+		} else {
+			end = keyPosition(line, def.endField, def.endChar, def.skipEndBlanks, separator)
+		}
+	}
+
+	if end < start {
+		return ""
+	}
+	return line[start:end]
+}
+
+// keyPosition - байтовое смещение через skipChars символов от начала поля
+//
+// как и GNU sort, смещение может уйти за конец поля, но не дальше конца строки: у "ab cd" ключ -k1.2,1.4 - "b c"
+func keyPosition(line string, field int, skipChars int, skipBlanks bool, separator rune) int {
+	position, _ := fieldBounds(line, field, separator)
+	if skipBlanks {
+		for position < len(line) && isBlank(line[position]) {
+			position++
+		}
+	}
+	for ; skipChars > 0 && position < len(line); skipChars-- {
+		_, size := utf8.DecodeRuneInString(line[position:])
+		position += size
+	}
+	return position
+}
+
+// fieldBounds возвращает границы поля field (с 1), поля нет - обе границы в конце строки
+//
+// без separator поля как в GNU sort: поле начинается с пробелов перед ним и идёт до следующих пробелов
+func fieldBounds(line string, field int, separator rune) (start int, end int) {
+	if separator != 0 {
+		for ; field > 1; field-- {
+			next := strings.IndexRune(line[start:], separator)
+			if next < 0 {
+				return len(line), len(line)
+			}
+			start += next + utf8.RuneLen(separator)
+		}
+		end = strings.IndexRune(line[start:], separator)
+		if end < 0 {
+			return start, len(line)
+		}
+		return start, start + end
+	}
+
+	for ; field > 0; field-- {
+		start = end
+		for end < len(line) && isBlank(line[end]) {
+			end++
+		}
+		for end < len(line) && !isBlank(line[end]) {
+			end++
+		}
+	}
+	if start == end {
+		return len(line), len(line)
+	}
+	return start, end
+}
+
+// isBlank - пробел или табуляция
+func isBlank(b byte) bool {
+	return b == ' ' || b == '\t'
+}
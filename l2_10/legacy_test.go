@@ -0,0 +1,233 @@
+package main
+
+// прежняя сортировка вставкой в связный список: по ней проверяется, что вывод не изменился,
+// и с ней сравниваются бенчмарки
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type node struct {
+	value string
+	next  *node
+}
+
+type linkedList struct {
+	head *node
+}
+
+func newLinkedList() *linkedList {
+	return &linkedList{}
+}
+
+// insertSorted вставляет элемент в связный список
+//
+// при проходе порядок сортировки оценивается так:
+// если сравнивать надо как числа, то результат сравнения = число(значение) > число(из списка)
+// если строки, то сравнивать значение и из списка напрямую
+//
+// если сортировка по возрастанию, результат должен быть false
+// если сортировка по убыванию, то результат должен быть true
+//
+//	найдя такой результат, выходим и вставляем
+//
+// compareResult == reverse -> break -> insert
+//
+// для сравнения в форматах памяти числа хранятся в int64, потому что битов в терабайте очень много
+// для сравнения в формате числа и месяца строковые значения преобразуются в int
+func (l *linkedList) insertSorted(value string, reverse bool, onlyUnique bool, asNumber bool, asMonth bool, asMemory bool) (affectedOrder bool, err error) {
+	currentNode := l.head
+
+	var valueAsInt int
+	var currentAsInt int
+	var valueAsInt64 int64
+	var currentAsInt64 int64
+
+	if asNumber {
+		valueAsInt, err = strconv.Atoi(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid int number to insert %s: %w", value, err)
+		}
+	} else if asMonth {
+		valueAsInt, err = monthToInt(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid month to insert %s: %w", value, err)
+		}
+	} else if asMemory {
+		valueAsInt64, err = memoryUnitToInt(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid memory unit to insert %s: %w", value, err)
+		}
+	}
+
+	var compareResult bool
+	var prevNode *node
+	for currentNode != nil {
+		if asNumber {
+			currentAsInt, err = strconv.Atoi(currentNode.value)
+			if err != nil {
+				return false, fmt.Errorf("invalid int number in list %s: %w", currentNode.value, err)
+			}
+			compareResult = valueAsInt > currentAsInt
+		} else if asMonth {
+			currentAsInt, err = monthToInt(currentNode.value)
+			if err != nil {
+				return false, fmt.Errorf("invalid month to insert %s: %w", currentNode.value, err)
+			}
+			compareResult = valueAsInt > currentAsInt
+		} else if asMemory {
+			currentAsInt64, err = memoryUnitToInt(currentNode.value)
+			if err != nil {
+				return false, fmt.Errorf("invalid memory in list %s: %w", currentNode.value, err)
+			}
+			compareResult = valueAsInt64 > currentAsInt64
+		} else {
+			compareResult = value > currentNode.value
+		}
+
+		if compareResult == reverse {
+			break
+		}
+
+		prevNode = currentNode
+		currentNode = currentNode.next
+		if currentNode == nil {
+			break
+		}
+	}
+
+	if currentNode != nil && currentNode.value == value && onlyUnique {
+		return
+	}
+
+	affectedOrder = currentNode != nil
+
+	if prevNode == nil {
+		l.head = &node{value, l.head}
+		return
+	}
+	prevNode.next = &node{value, currentNode}
+	return
+}
+
+func (l *linkedList) string() string {
+	result := make([]string, 0)
+	currentNode := l.head
+	for currentNode != nil {
+		result = append(result, currentNode.value)
+		currentNode = currentNode.next
+	}
+	return strings.Join(result, "\n")
+}
+
+// linkedListSort сортирует значения так, как это делал связный список, вывод как у fmt.Println
+func linkedListSort(values []string, options sortOptions) (string, error) {
+	result := newLinkedList()
+	for _, value := range values {
+		if _, err := result.insertSorted(value, options.reverse, options.onlyUnique, options.asNumber, options.asMonth, options.asMemory); err != nil {
+			return "", err
+		}
+	}
+	return result.string() + "\n", nil
+}
+
+// randomValues генерирует значения, которые подходят под флаги options
+func randomValues(random *rand.Rand, count int, options sortOptions) []string {
+	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	units := []string{"K", "M", "G"}
+
+	values := make([]string, 0, count)
+	for range count {
+		switch {
+		// равные ключи здесь только у одинаковых строк: разные строки с равными ключами (как "07" и "7")
+		// список ставил новой раньше, а теперь они упорядочены целиком, как в GNU sort (см. TestTies)
+		case options.asNumber:
+			values = append(values, strconv.Itoa(random.Intn(200)-100))
+		case options.asMonth:
+			values = append(values, months[random.Intn(len(months))])
+		case options.asMemory:
+			values = append(values, strconv.Itoa(random.Intn(99)+1)+units[random.Intn(len(units))])
+		default:
+			values = append(values, strconv.Itoa(random.Intn(count)))
+		}
+	}
+	return values
+}
+
+// equivalenceOptions - флаги, на которых вывод сравнивается со связным списком
+//
+// -r -u не сравнивается: список при -r не убирал повторы
+var equivalenceOptions = []sortOptions{
+	{},
+	{reverse: true},
+	{onlyUnique: true},
+	{asNumber: true},
+	{asNumber: true, reverse: true},
+	{asNumber: true, onlyUnique: true},
+	{asMonth: true},
+	{asMonth: true, reverse: true},
+	{asMemory: true, onlyUnique: true},
+	{asMemory: true, reverse: true},
+}
+
+func TestSameAsLinkedList(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, options := range equivalenceOptions {
+		for _, count := range []int{0, 1, 2, 10, 300} {
+			values := randomValues(random, count, options)
+			expected, err := linkedListSort(values, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			input := ""
+			for _, value := range values {
+				input += value + "\n"
+			}
+			for _, bufferSize := range []int64{1, 1000, defaultBufferSize} {
+				options.bufferSize = bufferSize
+				if result := sortString(t, input, options); result != expected {
+					t.Errorf("%+v, %d values: got %q, expected %q", options, count, result, expected)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkSort(b *testing.B, count int, options sortOptions, linkedList bool) {
+	values := randomValues(rand.New(rand.NewSource(1)), count, options)
+	input := strings.Join(values, "\n") + "\n"
+	options.bufferSize = defaultBufferSize
+	temp := newTempStorage(b.TempDir())
+	defer temp.cleanup()
+
+	b.ResetTimer()
+	for range b.N {
+		if linkedList {
+			if _, err := linkedListSort(values, options); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		if err := sortLines(strings.NewReader(input), &bytes.Buffer{}, options, temp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLinkedList1k(b *testing.B)  { benchmarkSort(b, 1000, sortOptions{}, true) }
+func BenchmarkSortLines1k(b *testing.B)   { benchmarkSort(b, 1000, sortOptions{}, false) }
+func BenchmarkLinkedList10k(b *testing.B) { benchmarkSort(b, 10000, sortOptions{}, true) }
+func BenchmarkSortLines10k(b *testing.B)  { benchmarkSort(b, 10000, sortOptions{}, false) }
+func BenchmarkLinkedListNumber10k(b *testing.B) {
+	benchmarkSort(b, 10000, sortOptions{asNumber: true}, true)
+}
+func BenchmarkSortLinesNumber10k(b *testing.B) {
+	benchmarkSort(b, 10000, sortOptions{asNumber: true}, false)
+}
+func BenchmarkSortLines100k(b *testing.B) { benchmarkSort(b, 100000, sortOptions{}, false) }
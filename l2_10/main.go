@@ -2,17 +2,25 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"unicode/utf8"
 )
 
 func memoryUnitToInt(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty memory unit")
+	}
 	lastChar, _ := utf8.DecodeLastRune([]byte(s))
 
 	var valueAsInt64 int64
@@ -81,122 +89,206 @@ func monthToInt(s string) (int, error) {
 	}
 }
 
-type node struct {
-	value string
-	next  *node
-}
-
-type linkedList struct {
-	head *node
+// sortKey - ключ, разобранный один раз: при -M и -h сравнивается number, при -n - число из integer и fraction,
+// иначе text
+type sortKey struct {
+	text   string
+	number int64
+	// negative, integer, fraction - число -n: знак, цифры до точки без ведущих нулей и после без хвостовых
+	negative bool
+	integer  string
+	fraction string
 }
 
-func newLinkedList() *linkedList {
-	return &linkedList{}
-}
-
-// insertSorted вставляет элемент в связный список
-//
-// при проходе порядок сортировки оценивается так:
-// если сравнивать надо как числа, то результат сравнения = число(значение) > число(из списка)
-// если строки, то сравнивать значение и из списка напрямую
+// parseKey разбирает ключ по флагам, приоритет как раньше: -n, потом -M, потом -h
 //
-// если сортировка по возрастанию, результат должен быть false
-// если сортировка по убыванию, то результат должен быть true
-//
-//	найдя такой результат, выходим и вставляем
-//
-// compareResult == reverse -> break -> insert
-//
-// для сравнения в форматах памяти числа хранятся в int64, потому что битов в терабайте очень много
-// для сравнения в формате числа и месяца строковые значения преобразуются в int
-func (l *linkedList) insertSorted(value string, reverse bool, onlyUnique bool, asNumber bool, asMonth bool, asMemory bool) (affectedOrder bool, err error) {
-	currentNode := l.head
-
-	var valueAsInt int
-	var currentAsInt int
-	var valueAsInt64 int64
-	var currentAsInt64 int64
-
-	if asNumber {
-		valueAsInt, err = strconv.Atoi(value)
-		if err != nil {
-			return false, fmt.Errorf("invalid int number to insert %s: %w", value, err)
+// как в GNU sort, берётся только начало ключа после пробелов: у "3:Jan" число 3, а ключ без числа,
+// месяца или объёма памяти не ошибка - это 0, неизвестный месяц меньше января
+// для сравнения в форматах памяти нужен int64, потому что битов в терабайте очень много
+func parseKey(value string, def keyDef) sortKey {
+	if def.asNumber || def.asMonth || def.asMemory {
+		value = strings.TrimLeft(value, " \t")
+	}
+	switch {
+	case def.asNumber:
+		return parseNumberPrefix(value)
+	case def.asMonth:
+		if len(value) < 3 {
+			return sortKey{}
 		}
-	} else if asMonth {
-		valueAsInt, err = monthToInt(value)
+		// "JAN" и "january" - тоже январь
+		valueAsInt, err := monthToInt(strings.ToUpper(value[:1]) + strings.ToLower(value[1:3]))
 		if err != nil {
-			return false, fmt.Errorf("invalid month to insert %s: %w", value, err)
+			return sortKey{}
 		}
-	} else if asMemory {
-		valueAsInt64, err = memoryUnitToInt(value)
+		return sortKey{number: int64(valueAsInt)}
+	case def.asMemory:
+		number := numberPrefixLength(value, false)
+		if number < len(value) && strings.IndexByte("BKMGT", value[number]) >= 0 {
+			number++
+		}
+		valueAsInt64, err := memoryUnitToInt(value[:number])
 		if err != nil {
-			return false, fmt.Errorf("invalid memory unit to insert %s: %w", value, err)
+			return sortKey{}
 		}
+		return sortKey{number: valueAsInt64}
+	default:
+		return sortKey{text: value}
 	}
+}
 
-	var compareResult bool
-	var prevNode *node
-	for currentNode != nil {
-		if asNumber {
-			currentAsInt, err = strconv.Atoi(currentNode.value)
-			if err != nil {
-				return false, fmt.Errorf("invalid int number in list %s: %w", currentNode.value, err)
-			}
-			compareResult = valueAsInt > currentAsInt
-		} else if asMonth {
-			currentAsInt, err = monthToInt(currentNode.value)
-			if err != nil {
-				return false, fmt.Errorf("invalid month to insert %s: %w", currentNode.value, err)
-			}
-			compareResult = valueAsInt > currentAsInt
-		} else if asMemory {
-			currentAsInt64, err = memoryUnitToInt(currentNode.value)
-			if err != nil {
-				return false, fmt.Errorf("invalid memory in list %s: %w", currentNode.value, err)
-			}
-			compareResult = valueAsInt64 > currentAsInt64
-		} else {
-			compareResult = value > currentNode.value
+// numberPrefixLength - длина числа в начале s: знак минус, цифры и, если fraction, дробная часть после точки
+func numberPrefixLength(s string, fraction bool) int {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	digits := countDigits(s[i:])
+	i += digits
+	if fraction && i < len(s) && s[i] == '.' {
+		if fractionDigits := countDigits(s[i+1:]); digits > 0 || fractionDigits > 0 {
+			return i + 1 + fractionDigits
 		}
+	}
+	if digits == 0 {
+		return 0
+	}
+	return i
+}
 
-		if compareResult == reverse {
-			break
-		}
+// parseNumberPrefix разбирает число в начале s любой длины, "1.50" и "01.5" равны, "-0" равен 0
+func parseNumberPrefix(s string) sortKey {
+	s = s[:numberPrefixLength(s, true)]
+	key := sortKey{}
+	if strings.HasPrefix(s, "-") {
+		key.negative = true
+		s = s[1:]
+	}
+	integer, fraction, _ := strings.Cut(s, ".")
+	key.integer = strings.TrimLeft(integer, "0")
+	key.fraction = strings.TrimRight(fraction, "0")
+	if key.integer == "" && key.fraction == "" {
+		key.negative = false
+	}
+	return key
+}
 
-		prevNode = currentNode
-		currentNode = currentNode.next
-		if currentNode == nil {
-			break
+// compareNumbers сравнивает числа -n поразрядно, так что длина не ограничена
+func compareNumbers(a sortKey, b sortKey) int {
+	if a.negative != b.negative {
+		if a.negative {
+			return -1
 		}
+		return 1
 	}
 
-	if currentNode != nil && currentNode.value == value && onlyUnique {
-		return
+	compareResult := cmp.Compare(len(a.integer), len(b.integer))
+	if compareResult == 0 {
+		compareResult = strings.Compare(a.integer, b.integer)
 	}
+	if compareResult == 0 {
+		// у дробных частей без хвостовых нулей строковое сравнение совпадает с числовым
+		compareResult = strings.Compare(a.fraction, b.fraction)
+	}
+	if a.negative {
+		return -compareResult
+	}
+	return compareResult
+}
+
+// compareKeys возвращает -1, если a меньше b, 0 если равны, 1 если больше
+func compareKeys(a sortKey, b sortKey, def keyDef) int {
+	switch {
+	case def.asNumber:
+		return compareNumbers(a, b)
+	case def.asMonth || def.asMemory:
+		return cmp.Compare(a.number, b.number)
+	default:
+		return strings.Compare(a.text, b.text)
+	}
+}
 
-	affectedOrder = currentNode != nil
+// sortItem - строка с разобранными ключами и номером во входных данных
+type sortItem struct {
+	keys  []sortKey
+	line  string
+	index int
+}
+
+// makeItem разбирает ключи строки, options.keys уже должны быть из resolveKeys
+func makeItem(line string, index int, options sortOptions) sortItem {
+	// interpretedString - строка, из которой берутся ключи (обрезанная по пробелам при -b)
+	interpretedString := line
+	if options.trimTrailing {
+		interpretedString = strings.TrimRight(interpretedString, " ")
+	}
 
-	if prevNode == nil {
-		l.head = &node{value, l.head}
-		return
+	keys := make([]sortKey, len(options.keys))
+	for i, def := range options.keys {
+		keys[i] = parseKey(extractKey(interpretedString, def, options.separator), def)
 	}
-	prevNode.next = &node{value, currentNode}
-	return
+	return sortItem{keys: keys, line: line, index: index}
 }
 
-func (l *linkedList) string() string {
-	result := make([]string, 0)
-	currentNode := l.head
-	for currentNode != nil {
-		result = append(result, currentNode.value)
-		currentNode = currentNode.next
+// compareItems сравнивает строки как GNU sort: по ключам по очереди, при равенстве всех ключей - целиком
+// побайтово (с учётом -r), кроме -u: там строки с равными ключами считаются равными
+func compareItems(a sortItem, b sortItem, options sortOptions) int {
+	for i, def := range options.keys {
+		compareResult := compareKeys(a.keys[i], b.keys[i], def)
+		if def.reverse {
+			compareResult = -compareResult
+		}
+		if compareResult != 0 {
+			return compareResult
+		}
+	}
+	if options.onlyUnique {
+		return 0
 	}
-	return strings.Join(result, "\n")
+
+	compareResult := strings.Compare(a.line, b.line)
+	if options.reverse {
+		return -compareResult
+	}
+	return compareResult
 }
 
+// sortItems сортирует кусок за O(n log n), совсем равные строки остаются в порядке входных данных
+func sortItems(items []sortItem, options sortOptions) {
+	slices.SortFunc(items, func(a, b sortItem) int {
+		if compareResult := compareItems(a, b, options); compareResult != 0 {
+			return compareResult
+		}
+		return cmp.Compare(a.index, b.index)
+	})
+}
+
+// sortOptions - флаги сортировки
+type sortOptions struct {
+	// keys - ключи из -k по порядку, пусто - вся строка
+	keys []keyDef
+	// separator - разделитель полей из -t, 0 - поля разделяются пробелами
+	separator      rune
+	asNumber       bool
+	asMonth        bool
+	asMemory       bool
+	reverse        bool
+	onlyUnique     bool
+	trimTrailing   bool
+	tellIfUnsorted bool
+	// bufferSize - сколько байт строк держим в памяти, дальше сортированные куски уходят во временные файлы
+	bufferSize int64
+	// tmpDir - где создавать временные файлы
+	tmpDir string
+}
+
+// defaultBufferSize - размер буфера без -S
+const defaultBufferSize = 64 * 1024 * 1024
+
 func main() {
 	/*
-		kFlag := flag.Int("k", -1, "column to sort, count from 0, set -1 to disable")
+		kFlag := flag.String("k", "", "sort key POS1[,POS2], POS is F[.C][bnMhr], fields and chars count from 1, repeatable")
+		tFlag := flag.String("t", "", "field separator, default: fields are separated by blanks")
 		// converters priority from high to low
 		nFlag := flag.Bool("n", false, "interpret sorted part of line as number")
 		mFlag := flag.Bool("M", false, "interpret sorted part of line as month: Jan, Feb, Mar ...")
@@ -204,112 +296,203 @@ func main() {
 		//
 		rFlag := flag.Bool("r", false, "reverse")
 		uFlag := flag.Bool("u", false, "only unique")
-		bFlag := flag.Bool("b", false, "ignore trailing blanks")
+		bFlag := flag.Bool("b", false, "ignore trailing blanks (b in -k ignores leading blanks of the field, as in GNU sort)")
 		cFlag := flag.Bool("c", false, "check and tell if data is sorted")
+		SFlag := flag.String("S", "64M", "buffer size: number with suffix b, K (default), M, G, T")
+		TFlag := flag.String("T", os.TempDir(), "directory for temporary files")
 		flag.Parse()
 	*/
+	options, err := parseArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Сканируем флаги вручную! Потому что надо обеспечить комбинации
-
-	// region flags
-	var err error
-
-	var columnToSort = -1
-	var asNumber = false
-	var asMonth = false
-	var asMemory = false
-	var reverse = false
-	var onlyUnique = false
-	var trimTrailing = false
-	var tellIfUnsorted = false
+	// временные файлы удаляются и при ошибке, и по Ctrl+C
+	temp := newTempStorage(options.tmpDir)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		temp.cleanup()
+		os.Exit(130)
+	}()
+
+	output := bufio.NewWriter(os.Stdout)
+	err = sortLines(os.Stdin, output, options, temp)
+	temp.cleanup()
+	if err == nil {
+		err = output.Flush()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	scanningK := false
+// parseArgs сканирует флаги вручную! Потому что надо обеспечить комбинации
+//
+// у -k, -t, -S и -T значение идёт сразу за флагом (-k2,2n) или следующим аргументом (-k 2,2n)
+func parseArgs(args []string) (options sortOptions, err error) {
+	options = sortOptions{
+		bufferSize: defaultBufferSize,
+		tmpDir:     os.TempDir(),
+	}
 
-	for _, flagCombination := range os.Args[1:] {
-		if scanningK {
-			columnToSort, err = strconv.Atoi(flagCombination)
-			if err != nil {
-				log.Fatal("invalid -k column to sort")
-			}
-			scanningK = false
-			continue
-		}
-		for _, flagRune := range flagCombination {
+nextArg:
+	for i := 0; i < len(args); i++ {
+		flagCombination := args[i]
+		for j, flagRune := range flagCombination {
 			switch flagRune {
-			case 'k':
-				scanningK = true
 			case 'n':
-				asNumber = true
+				options.asNumber = true
 			case 'M':
-				asMonth = true
+				options.asMonth = true
 			case 'h':
-				asMemory = true
+				options.asMemory = true
 			case 'r':
-				reverse = true
+				options.reverse = true
 			case 'u':
-				onlyUnique = true
+				options.onlyUnique = true
 			case 'b':
-				trimTrailing = true
+				options.trimTrailing = true
 			case 'c':
-				tellIfUnsorted = true
+				options.tellIfUnsorted = true
+			case 'k', 't', 'S', 'T':
+				value := flagCombination[j+1:]
+				if value == "" {
+					i++
+					if i == len(args) {
+						return options, fmt.Errorf("option -%c requires a value", flagRune)
+					}
+					value = args[i]
+				}
+
+				switch flagRune {
+				case 'k':
+					def, err := parseKeyDef(value)
+					if err != nil {
+						return options, err
+					}
+					options.keys = append(options.keys, def)
+				case 't':
+					separator, size := utf8.DecodeRuneInString(value)
+					if size != len(value) || separator == '\n' {
+						return options, fmt.Errorf("invalid -t separator '%s', must be one character", value)
+					}
+					options.separator = separator
+				case 'T':
+					options.tmpDir = value
+				case 'S':
+					if options.bufferSize, err = parseBufferSize(value); err != nil {
+						return options, err
+					}
+				}
+				continue nextArg
 			}
 		}
 	}
-	// endregion
+	return options, nil
+}
 
-	// В linked list просто вставлять элемент в нужное место
-	result := newLinkedList()
+// parseBufferSize разбирает размер как в GNU sort: число и суффикс b (байты), K, M, G, T, без суффикса - K
+func parseBufferSize(s string) (int64, error) {
+	multiplier := int64(1024)
+	lastChar, lastSize := utf8.DecodeLastRuneInString(s)
+	switch lastChar {
+	case 'b':
+		multiplier = 1
+	case 'k', 'K':
+		multiplier = 1024
+	case 'M':
+		multiplier = 1024 * 1024
+	case 'G':
+		multiplier = 1024 * 1024 * 1024
+	case 'T':
+		multiplier = 1024 * 1024 * 1024 * 1024
+	default:
+		if lastChar < '0' || lastChar > '9' {
+			return 0, fmt.Errorf("invalid -S buffer size '%s'", s)
+		}
+		lastSize = 0
+	}
+	number := s[:len(s)-lastSize]
 
-	affectedOrder := false
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 || size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid -S buffer size '%s'", s)
+	}
+	return size * multiplier, nil
+}
 
-	// читаем данные из STDIN, которые нам присылает пайплайн
-	reader := bufio.NewReader(os.Stdin)
-	var input string
+// sortLines читает строки из in, сортирует и пишет в output
+//
+// ключ каждой строки разбирается один раз, строки копятся, пока не наберётся options.bufferSize байт,
+// потом сортируются и уходят во временный файл куском, в конце куски сливаются через кучу (см. mergeRuns)
+func sortLines(in io.Reader, output io.Writer, options sortOptions, temp *tempStorage) error {
+	options.keys = resolveKeys(options)
+
+	var items []sortItem
+	var used int64
+	var runs []runReader
+
+	var previous sortItem
+	hasPrevious := false
+	unsorted := false
+	index := 0
+
+	// читаем данные, которые нам присылает пайплайн
+	reader := bufio.NewReader(in)
 	for {
-		input, err = reader.ReadString('\n')
+		input, err := reader.ReadString('\n')
 
 		// При конце файла выходим, иначе показываем ошибку чтения
-		errors.Is(err, nil)
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		// убираем \n который остаётся после ReadString
 
-		// input - изначальная версия строки
+		// input - изначальная версия строки, она и выводится
 		input = strings.TrimSuffix(input, "\n")
 
-		// interpretedString - обрабатываемая часть строки (колонка и/или строка обрезанная по пробелам)
-		interpretedString := input
-		if trimTrailing {
-			interpretedString = strings.TrimRight(interpretedString, " ")
-		}
-
-		// пытаемся взять колонку в качестве interpretedString если номер указан
-		if columnToSort >= 0 {
-			columns := strings.Split(interpretedString, "\t")
-			if columnToSort >= len(columns) {
-				log.Fatal(fmt.Errorf("column too high: %d, only %d columns", columnToSort, len(columns)))
-			}
+		// ключи вырезаются и разбираются один раз
+		item := makeItem(input, index, options)
+		items = append(items, item)
+		index++
 
-			interpretedString = columns[columnToSort]
+		// если строка должна стоять раньше предыдущей, значит данные не сортированы
+		if hasPrevious && !unsorted {
+			unsorted = compareItems(item, previous, options) < 0
 		}
+		previous, hasPrevious = item, true
 
-		// готово, остальные преобразования сделает insert
-		affectedOrder, err = result.insertSorted(interpretedString, reverse, onlyUnique, asNumber, asMonth, asMemory)
-		if err != nil {
-			log.Fatal("error inserting value in list:", err)
+		// буфер полон, сбрасываем сортированный кусок на диск
+		used += int64(len(input)) + lineOverhead + keyOverhead*int64(len(item.keys))
+		if used >= options.bufferSize {
+			sortItems(items, options)
+			run, err := temp.writeRun(items, options)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			items = nil
+			used = 0
 		}
 	}
 
-	// результат готов после прохода по STDIN, выведем
-	fmt.Println(result.string())
+	// последний кусок сливаем прямо из памяти
+	sortItems(items, options)
+	runs = append(runs, &memoryRun{items: items})
+	if err := mergeRuns(output, runs, options, temp); err != nil {
+		return err
+	}
 
-	// если же при получении строк пришлось некоторые переставить, значит данные не сортированы
-	// есть флаг, который предписывает вывести это
-	if tellIfUnsorted && affectedOrder {
-		fmt.Println("-- input data is not sorted")
+	// есть флаг, который предписывает сказать, что данные не сортированы
+	if options.tellIfUnsorted && unsorted {
+		if _, err := fmt.Fprintln(output, "-- input data is not sorted"); err != nil {
+			return err
+		}
 	}
+	return nil
 }
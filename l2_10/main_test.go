@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	options, err := parseArgs([]string{"-nr", "-k", "2", "-S10M", "-T", "/var/tmp", "-u", "-k3,3n", "-t", ":"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !options.asNumber || !options.reverse || !options.onlyUnique || len(options.keys) != 2 ||
+		options.keys[0].startField != 2 || options.keys[1].endField != 3 || !options.keys[1].asNumber ||
+		options.separator != ':' || options.bufferSize != 10*1024*1024 || options.tmpDir != "/var/tmp" {
+		t.Errorf("unexpected options: %+v", options)
+	}
+
+	for _, args := range [][]string{{"-S"}, {"-S", "x"}, {"-k"}, {"-k", "a"}, {"-k0"}, {"-t", "ab"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestParseBufferSize(t *testing.T) {
+	for input, expected := range map[string]int64{"100b": 100, "2": 2048, "3K": 3072, "1M": 1 << 20, "1G": 1 << 30} {
+		size, err := parseBufferSize(input)
+		if err != nil || size != expected {
+			t.Errorf("%s: got %d, %v, expected %d", input, size, err, expected)
+		}
+	}
+	for _, input := range []string{"", "M", "-1", "0", "1X", "99999999999T"} {
+		if _, err := parseBufferSize(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+// sortString сортирует строки input с заданным размером буфера
+func sortString(t *testing.T, input string, options sortOptions) string {
+	t.Helper()
+	options.tmpDir = t.TempDir()
+	temp := newTempStorage(options.tmpDir)
+	defer temp.cleanup()
+
+	output := bytes.Buffer{}
+	if err := sortLines(strings.NewReader(input), &output, options, temp); err != nil {
+		t.Fatal(err)
+	}
+	return output.String()
+}
+
+func TestSortLines(t *testing.T) {
+	input := "c\nb\na\nb\n"
+	for _, v := range []struct {
+		options  sortOptions
+		expected string
+	}{
+		{sortOptions{}, "a\nb\nb\nc\n"},
+		{sortOptions{reverse: true}, "c\nb\nb\na\n"},
+		{sortOptions{onlyUnique: true}, "a\nb\nc\n"},
+		{sortOptions{reverse: true, onlyUnique: true}, "c\nb\na\n"},
+		{sortOptions{tellIfUnsorted: true}, "a\nb\nb\nc\n-- input data is not sorted\n"},
+	} {
+		for _, bufferSize := range []int64{1, defaultBufferSize} {
+			v.options.bufferSize = bufferSize
+			if result := sortString(t, input, v.options); result != v.expected {
+				t.Errorf("%+v: got %q, expected %q", v.options, result, v.expected)
+			}
+		}
+	}
+
+	if result := sortString(t, "", sortOptions{bufferSize: 1}); result != "\n" {
+		t.Errorf("empty input: got %q", result)
+	}
+}
+
+func TestExternalSortMatchesMemory(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	lines := make([]string, 0, 1000)
+	for range 1000 {
+		lines = append(lines, fmt.Sprintf("%d\t%d", random.Intn(100), random.Intn(5)))
+	}
+	input := strings.Join(lines, "\n") + "\n"
+
+	for _, options := range []sortOptions{
+		{keys: parseKeyDefs(t, "1,1"), asNumber: true},
+		{keys: parseKeyDefs(t, "1,1"), asNumber: true, reverse: true},
+		{keys: parseKeyDefs(t, "2,2", "1,1n"), onlyUnique: true, separator: '\t'},
+		{keys: parseKeyDefs(t, "2,2r", "1,1n")},
+		{},
+	} {
+		options.bufferSize = defaultBufferSize
+		expected := sortString(t, input, options)
+		// каждая строка - отдельный кусок, кусков больше maxMergeRuns
+		for _, bufferSize := range []int64{1, 500} {
+			options.bufferSize = bufferSize
+			if result := sortString(t, input, options); result != expected {
+				t.Errorf("%+v: external sort differs from sorting in memory", options)
+			}
+		}
+	}
+}
+
+// failingReader - вход, чтение которого обрывается ошибкой
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestTempFilesRemovedOnError(t *testing.T) {
+	dir := t.TempDir()
+	temp := newTempStorage(dir)
+	options := sortOptions{asNumber: true, bufferSize: 1, tmpDir: dir}
+
+	input := io.MultiReader(strings.NewReader("3\n1\n2\n"), failingReader{})
+	err := sortLines(input, &bytes.Buffer{}, options, temp)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) == 0 {
+		t.Fatal("runs should be written before the error")
+	}
+
+	temp.cleanup()
+	if entries, _ = os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+	if _, err = temp.create(); err == nil {
+		t.Error("files mustn't be created after cleanup")
+	}
+}
+
+// parseKeyDefs разбирает несколько -k
+func parseKeyDefs(t *testing.T, specs ...string) []keyDef {
+	t.Helper()
+	keys := make([]keyDef, 0, len(specs))
+	for _, spec := range specs {
+		def, err := parseKeyDef(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, def)
+	}
+	return keys
+}
+
+func TestParseKeyDef(t *testing.T) {
+	for spec, expected := range map[string]keyDef{
+		"2":       {startField: 2, startChar: 1},
+		"2,2":     {startField: 2, startChar: 1, endField: 2},
+		"2.3,2.5": {startField: 2, startChar: 3, endField: 2, endChar: 5},
+		"3,3nr":   {startField: 3, startChar: 1, endField: 3, asNumber: true, reverse: true, ownOrder: true},
+		"1b,1b":   {startField: 1, startChar: 1, endField: 1, skipStartBlanks: true, skipEndBlanks: true, ownOrder: true},
+		"4M":      {startField: 4, startChar: 1, asMonth: true, ownOrder: true},
+	} {
+		def, err := parseKeyDef(spec)
+		if err != nil || def != expected {
+			t.Errorf("%s: got %+v, %v, expected %+v", spec, def, err, expected)
+		}
+	}
+
+	for _, spec := range []string{"", "0", "1.0", "1,0", "a", "1.", "1x", "1,2.x"} {
+		if _, err := parseKeyDef(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestExtractKey(t *testing.T) {
+	line := "  ab cdef\tgh"
+	for _, v := range []struct {
+		spec      string
+		separator rune
+		expected  string
+	}{
+		{"1", 0, line},
+		{"1,1", 0, "  ab"},
+		{"1b,1", 0, "ab"},
+		{"2,2", 0, " cdef"},
+		{"2.2,2.4", 0, "cde"},
+		{"2.2b,2.4b", 0, "def"},
+		{"3,3", 0, "\tgh"},
+		{"4", 0, ""},
+		{"2.10,2", 0, ""},
+		{"2,1", 0, ""},
+		{"2", '\t', "gh"},
+		{"1.3,1.4", '\t', "ab"},
+		{"3,3", '\t', ""},
+	} {
+		def := parseKeyDefs(t, v.spec)[0]
+		if result := extractKey(line, def, v.separator); result != v.expected {
+			t.Errorf("%s, separator %q: got %q, expected %q", v.spec, v.separator, result, v.expected)
+		}
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	input := "alice 30 Mar\nbob 25 Jan\ncarol 30 Jan\ndave 4 Feb\neve 30 Mar\n"
+	for _, v := range []struct {
+		options  sortOptions
+		expected string
+	}{
+		// поля с 1, а не с 0, выводятся строки целиком
+		{sortOptions{keys: parseKeyDefs(t, "2,2n")}, "dave 4 Feb\nbob 25 Jan\nalice 30 Mar\ncarol 30 Jan\neve 30 Mar\n"},
+		// равные по первому ключу сортируются по второму
+		{sortOptions{keys: parseKeyDefs(t, "2,2nr", "3,3M")}, "carol 30 Jan\nalice 30 Mar\neve 30 Mar\nbob 25 Jan\ndave 4 Feb\n"},
+		// ключ без своих флагов берёт глобальные
+		{sortOptions{keys: parseKeyDefs(t, "3,3", "1,1r"), asMonth: true}, "carol 30 Jan\nbob 25 Jan\ndave 4 Feb\neve 30 Mar\nalice 30 Mar\n"},
+		// -u оставляет первую из строк с равными ключами
+		{sortOptions{keys: parseKeyDefs(t, "2,2n"), onlyUnique: true}, "dave 4 Feb\nbob 25 Jan\nalice 30 Mar\n"},
+		{sortOptions{keys: parseKeyDefs(t, "1.2,1.2")}, "carol 30 Jan\ndave 4 Feb\nalice 30 Mar\nbob 25 Jan\neve 30 Mar\n"},
+	} {
+		for _, bufferSize := range []int64{1, defaultBufferSize} {
+			v.options.bufferSize = bufferSize
+			if result := sortString(t, input, v.options); result != v.expected {
+				t.Errorf("%+v: got %q, expected %q", v.options, result, v.expected)
+			}
+		}
+	}
+}
+
+func TestTies(t *testing.T) {
+	// строки с равными ключами сравниваются целиком, как в GNU sort, -r переворачивает и это сравнение
+	input := "7\n07\n5\n007\n"
+	if result := sortString(t, input, sortOptions{asNumber: true, bufferSize: 1}); result != "5\n007\n07\n7\n" {
+		t.Errorf("got %q", result)
+	}
+	if result := sortString(t, input, sortOptions{asNumber: true, reverse: true}); result != "7\n07\n007\n5\n" {
+		t.Errorf("reverse: got %q", result)
+	}
+	// у ключа -r свой, последнее сравнение по глобальному
+	if result := sortString(t, input, sortOptions{keys: parseKeyDefs(t, "1nr")}); result != "007\n07\n7\n5\n" {
+		t.Errorf("key reverse: got %q", result)
+	}
+	// при -u из равных остаётся первая во входных данных
+	if result := sortString(t, input, sortOptions{asNumber: true, onlyUnique: true, bufferSize: 1}); result != "5\n7\n" {
+		t.Errorf("unique: got %q", result)
+	}
+}
+
+func TestNumericKeyPrefix(t *testing.T) {
+	for _, v := range []struct {
+		input    string
+		options  sortOptions
+		expected string
+	}{
+		// ключ без конца идёт до конца строки, число берётся из его начала
+		{"b:10:Feb\na:3:Jan\nc:-1.5:Mar\n", sortOptions{keys: parseKeyDefs(t, "2n"), separator: ':'}, "c:-1.5:Mar\na:3:Jan\nb:10:Feb\n"},
+		{"b:10:Feb\na:3:Jan\nc:-1.5:Mar\n", sortOptions{keys: parseKeyDefs(t, "2nr"), separator: ':'}, "b:10:Feb\na:3:Jan\nc:-1.5:Mar\n"},
+		// у ключа свои флаги, глобальный -r переворачивает только последнее сравнение
+		{"b:10:Feb\na:3:Jan\nc:-1.5:Mar\n", sortOptions{keys: parseKeyDefs(t, "2n"), separator: ':', reverse: true}, "c:-1.5:Mar\na:3:Jan\nb:10:Feb\n"},
+		// у короткой строки ключ пустой, это 0
+		{"a 3\nb\nc -2\n", sortOptions{keys: parseKeyDefs(t, "2,2n")}, "c -2\nb\na 3\n"},
+		// не число - тоже 0, при равенстве строки сравниваются целиком
+		{"x\n1\n-1\nabc\n", sortOptions{asNumber: true}, "-1\nabc\nx\n1\n"},
+		{"1.50\n1.5\n01.25\n99999999999999999999\n2\n-0\n", sortOptions{asNumber: true}, "-0\n01.25\n1.5\n1.50\n2\n99999999999999999999\n"},
+		{"a Mar\nb\nc january\nd FEB\n", sortOptions{keys: parseKeyDefs(t, "2M")}, "b\nc january\nd FEB\na Mar\n"},
+		{"a 2K\nb\nc 1Mb\nd 3\n", sortOptions{keys: parseKeyDefs(t, "2h")}, "b\nd 3\na 2K\nc 1Mb\n"},
+	} {
+		for _, bufferSize := range []int64{1, defaultBufferSize} {
+			v.options.bufferSize = bufferSize
+			if result := sortString(t, v.input, v.options); result != v.expected {
+				t.Errorf("%q, %+v: got %q, expected %q", v.input, v.options, result, v.expected)
+			}
+		}
+	}
+}
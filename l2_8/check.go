@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"l2_8/ntpclock"
+)
+
+// monitoring plugin exit codes (Nagios, Icinga, Sensu...)
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+// checkNames are the status words printed at the start of the line, indexed by exit code
+var checkNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkThresholds are the limits of -check mode, zero stratum limits are disabled
+type checkThresholds struct {
+	warnOffset  time.Duration
+	critOffset  time.Duration
+	warnStratum uint
+	critStratum uint
+}
+
+// checkResult is the outcome of one check: the exit code and the line to print
+type checkResult struct {
+	code int
+	line string
+}
+
+// evaluateCheck compares the consensus against thresholds
+//
+// the offset is the absolute consensus offset, the stratum is the one of the system peer
+// (the truechimer with the smallest root distance). No consensus is UNKNOWN
+func evaluateCheck(samples []ntpclock.Sample, result ntpclock.Consensus, selectionErr error, source string, limits checkThresholds) checkResult {
+	if selectionErr != nil {
+		line := "NTP UNKNOWN: " + selectionErr.Error()
+		// the first failure usually tells why, e.g. a timeout or a kiss code
+		for _, sample := range samples {
+			if sample.Err != nil {
+				line += fmt.Sprintf(" (%s: %v)", sample.Address, sample.Err)
+				break
+			}
+		}
+		return checkResult{code: checkUnknown, line: line}
+	}
+
+	best := samples[result.Truechimers[0]].Response
+	for _, i := range result.Truechimers[1:] {
+		if samples[i].Response.RootDistance < best.RootDistance {
+			best = samples[i].Response
+		}
+	}
+
+	offset := result.Offset
+	if offset < 0 {
+		offset = -offset
+	}
+
+	code := checkOK
+	var reasons []string
+	switch {
+	case offset >= limits.critOffset:
+		code = checkCritical
+		reasons = append(reasons, fmt.Sprintf("offset %v >= %v", result.Offset, limits.critOffset))
+	case offset >= limits.warnOffset:
+		code = checkWarning
+		reasons = append(reasons, fmt.Sprintf("offset %v >= %v", result.Offset, limits.warnOffset))
+	}
+
+	// HTTP Date headers have no stratum
+	hasStratum := source == sourceNTP
+	if hasStratum {
+		stratum := uint(best.Stratum)
+		switch {
+		case limits.critStratum != 0 && stratum >= limits.critStratum:
+			code = checkCritical
+			reasons = append(reasons, fmt.Sprintf("stratum %d >= %d", stratum, limits.critStratum))
+		case limits.warnStratum != 0 && stratum >= limits.warnStratum:
+			code = max(code, checkWarning)
+			reasons = append(reasons, fmt.Sprintf("stratum %d >= %d", stratum, limits.warnStratum))
+		}
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "NTP %s: ", checkNames[code])
+	if len(reasons) > 0 {
+		line.WriteString(strings.Join(reasons, ", ") + "; ")
+	}
+	fmt.Fprintf(&line, "offset %v", result.Offset)
+	if hasStratum {
+		fmt.Fprintf(&line, ", stratum %d", best.Stratum)
+	}
+	fmt.Fprintf(&line, ", %d of %d servers, source %s", len(result.Truechimers), len(samples), source)
+
+	// perfdata: 'label'=value[UOM];warn;crit;min;max
+	fmt.Fprintf(&line, " | offset=%fs;%f;%f;;", result.Offset.Seconds(), limits.warnOffset.Seconds(), limits.critOffset.Seconds())
+	if hasStratum {
+		fmt.Fprintf(&line, " stratum=%d;%s;%s;0;16", best.Stratum, stratumLimit(limits.warnStratum), stratumLimit(limits.critStratum))
+	}
+	fmt.Fprintf(&line, " truechimers=%d;;;0;%d", len(result.Truechimers), len(samples))
+
+	return checkResult{code: code, line: line.String()}
+}
+
+// stratumLimit formats a stratum threshold for perfdata, a disabled one is empty
+func stratumLimit(limit uint) string {
+	if limit == 0 {
+		return ""
+	}
+	return fmt.Sprint(limit)
+}
+
+// exitCheck prints the status line and exits with the plugin code
+func exitCheck(result checkResult) {
+	fmt.Println(result.line)
+	os.Exit(result.code)
+}
+
+// exitUnknown replaces log.Fatal in -check mode: any failure must still be a valid plugin answer
+func exitUnknown(v ...any) {
+	exitCheck(checkResult{code: checkUnknown, line: "NTP UNKNOWN: " + fmt.Sprint(v...)})
+}
+
+// checkRequested reports whether args turn -check mode on, it's used when the flags can't be parsed
+func checkRequested(args []string) bool {
+	requested := false
+	for _, arg := range args {
+		if arg == "--" {
+			// flags end here, a -check after it is an argument
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "check" {
+			continue
+		}
+		if !hasValue {
+			requested = true
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		requested = err == nil && enabled
+	}
+	return requested
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"l2_8/ntpclock"
+)
+
+func TestEvaluateCheck(t *testing.T) {
+	limits := checkThresholds{
+		warnOffset:  100 * time.Millisecond,
+		critOffset:  time.Second,
+		warnStratum: 4,
+		critStratum: 8,
+	}
+	cases := []struct {
+		name     string
+		offset   time.Duration
+		stratum  uint8
+		source   string
+		expected int
+	}{
+		{"ok", 5 * time.Millisecond, 2, sourceNTP, checkOK},
+		{"warning offset", -200 * time.Millisecond, 2, sourceNTP, checkWarning},
+		{"critical offset", 3 * time.Second, 2, sourceNTP, checkCritical},
+		{"warning stratum", 5 * time.Millisecond, 5, sourceNTP, checkWarning},
+		{"critical stratum wins over warning offset", 200 * time.Millisecond, 9, sourceNTP, checkCritical},
+		{"http has no stratum", 5 * time.Millisecond, 0, sourceHTTPDate, checkOK},
+	}
+	for _, c := range cases {
+		samples := []ntpclock.Sample{{
+			Address:  "127.0.0.1:123",
+			Response: &ntp.Response{ClockOffset: c.offset, RootDistance: 10 * time.Millisecond, Stratum: c.stratum},
+		}}
+		result, err := ntpclock.SelectTruechimers(samples)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		check := evaluateCheck(samples, result, nil, c.source, limits)
+		if check.code != c.expected {
+			t.Errorf("%s: expected code %d, got %d: %s", c.name, c.expected, check.code, check.line)
+		}
+		if !strings.HasPrefix(check.line, "NTP "+checkNames[c.expected]+": ") {
+			t.Errorf("%s: unexpected status line: %s", c.name, check.line)
+		}
+		if strings.Contains(check.line, "\n") || !strings.Contains(check.line, " | offset=") {
+			t.Errorf("%s: expected one line with perfdata: %s", c.name, check.line)
+		}
+		if hasStratum := strings.Contains(check.line, " stratum="); hasStratum != (c.source == sourceNTP) {
+			t.Errorf("%s: stratum perfdata only for NTP: %s", c.name, check.line)
+		}
+	}
+}
+
+func TestEvaluateCheckUnknown(t *testing.T) {
+	samples := []ntpclock.Sample{{Address: "127.0.0.1:123", Err: ntp.ErrKissOfDeath}}
+	result, err := ntpclock.SelectTruechimers(samples)
+
+	check := evaluateCheck(samples, result, err, sourceNTP, checkThresholds{warnOffset: time.Second, critOffset: time.Second})
+	if check.code != checkUnknown || !strings.HasPrefix(check.line, "NTP UNKNOWN: ") {
+		t.Errorf("expected UNKNOWN without consensus, got %d: %s", check.code, check.line)
+	}
+}
+
+func TestCheckPerfdata(t *testing.T) {
+	samples := []ntpclock.Sample{{
+		Address:  "127.0.0.1:123",
+		Response: &ntp.Response{ClockOffset: -1500 * time.Microsecond, RootDistance: 10 * time.Millisecond, Stratum: 2},
+	}}
+	result, _ := ntpclock.SelectTruechimers(samples)
+
+	check := evaluateCheck(samples, result, nil, sourceNTP, checkThresholds{warnOffset: 100 * time.Millisecond, critOffset: time.Second, critStratum: 5})
+	_, perfdata, _ := strings.Cut(check.line, " | ")
+	expected := "offset=-0.001500s;0.100000;1.000000;; stratum=2;;5;0;16 truechimers=1;;;0;1"
+	if perfdata != expected {
+		t.Errorf("expected perfdata %q, got %q", expected, perfdata)
+	}
+}
+
+func TestCheckRequested(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"-check", "-warn", "bogus"}, true},
+		{[]string{"-warn", "bogus", "--check"}, true},
+		{[]string{"-check=true"}, true},
+		{[]string{"-check", "-check=false"}, false},
+		{[]string{"-check=bogus"}, false},
+		{[]string{"-warn", "bogus"}, false},
+		{[]string{"-checks"}, false},
+		{[]string{"-warn", "bogus", "--", "-check"}, false},
+		{[]string{"-check", "--", "-check=false"}, true},
+	}
+	for _, c := range cases {
+		if got := checkRequested(c.args); got != c.expected {
+			t.Errorf("%v: got %v, expected %v", c.args, got, c.expected)
+		}
+	}
+}
+
+// TestCheckBadFlag runs main in a child process: a flag error in -check mode must exit UNKNOWN
+func TestCheckBadFlag(t *testing.T) {
+	if os.Getenv("L2_8_RUN_MAIN") == "1" {
+		os.Args = append([]string{"l2_8"}, strings.Fields(os.Getenv("L2_8_ARGS"))...)
+		main()
+		return
+	}
+
+	for args, expected := range map[string]int{"-check -warn bogus": checkUnknown, "-warn bogus": 2} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCheckBadFlag$")
+		cmd.Env = append(os.Environ(), "L2_8_RUN_MAIN=1", "L2_8_ARGS="+args)
+		output, err := cmd.Output()
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != expected {
+			t.Errorf("%s: expected exit code %d, got %v", args, expected, err)
+		}
+		if expected == checkUnknown && !strings.HasPrefix(string(output), "NTP UNKNOWN: ") {
+			t.Errorf("%s: unexpected output %q", args, output)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// maxDriftSamples limits memory of the drift estimator, older samples are dropped first
+const maxDriftSamples = 4096
+
+// driftPoint is one measured offset at the moment elapsed since the beginning of the watch
+type driftPoint struct {
+	elapsed float64 // seconds, taken from the monotonic clock
+	offset  float64 // seconds
+}
+
+// driftEstimator keeps a running estimate of local clock frequency error and offset jitter
+//
+// drift is the slope of the least-squares line through (elapsed, offset) points.
+// offset is "server - local", so when the local clock runs fast the offset decreases,
+// that's why drift is reported with the opposite sign: positive ppm = local clock is fast.
+//
+// jitter is the RMS of differences between consecutive offsets, as in RFC 5905
+type driftEstimator struct {
+	points []driftPoint
+}
+
+// newDriftEstimator creates an empty estimator
+func newDriftEstimator() *driftEstimator {
+	return &driftEstimator{}
+}
+
+// add records one offset measurement
+func (d *driftEstimator) add(elapsed time.Duration, offset time.Duration) {
+	if len(d.points) >= maxDriftSamples {
+		d.points = d.points[1:]
+	}
+	d.points = append(d.points, driftPoint{elapsed.Seconds(), offset.Seconds()})
+}
+
+// driftPPM returns the frequency error of the local clock in parts per million
+//
+// ok is false until there are at least 2 points spread in time
+func (d *driftEstimator) driftPPM() (ppm float64, ok bool) {
+	n := float64(len(d.points))
+	if n < 2 {
+		return 0, false
+	}
+
+	var sumX, sumY float64
+	for _, p := range d.points {
+		sumX += p.elapsed
+		sumY += p.offset
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var covariance, variance float64
+	for _, p := range d.points {
+		dx := p.elapsed - meanX
+		covariance += dx * (p.offset - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0, false
+	}
+
+	slope := covariance / variance
+	return -slope * 1e6, true
+}
+
+// jitter returns the RMS of consecutive offset differences
+func (d *driftEstimator) jitter() time.Duration {
+	if len(d.points) < 2 {
+		return 0
+	}
+
+	var sum float64
+	for i := 1; i < len(d.points); i++ {
+		diff := d.points[i].offset - d.points[i-1].offset
+		sum += diff * diff
+	}
+	rms := math.Sqrt(sum / float64(len(d.points)-1))
+	return time.Duration(rms * float64(time.Second))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+)
+
+// addressListFlag is a repeatable flag that collects NTP server addresses
+//
+// both forms are accepted and can be mixed:
+//
+//	-address a -address b
+//	-address a,b
+type addressListFlag []string
+
+// String returns addresses joined by comma, used by flag package for defaults
+func (a *addressListFlag) String() string {
+	if a == nil {
+		return ""
+	}
+	return strings.Join(*a, ",")
+}
+
+// Set appends one or more comma-separated addresses
+func (a *addressListFlag) Set(value string) error {
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		*a = append(*a, address)
+	}
+	return nil
+}
@@ -2,10 +2,10 @@ module l2_8
 
 go 1.25.0
 
-require github.com/beevik/ntp v1.4.3
-
 require (
-	github.com/stretchr/testify v1.10.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
+	github.com/beevik/ntp v1.4.3
+	golang.org/x/net v0.25.0
+	golang.org/x/sys v0.31.0
 )
+
+require github.com/stretchr/testify v1.10.0 // indirect
@@ -1,21 +1,481 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/beevik/ntp"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"l2_8/ntpclock"
 )
 
+const defaultAddress = "time.google.com"
+
 func main() {
-	addressFlag := flag.String("address", "time.google.com", "NTP server address")
-	flag.Parse()
+	// ContinueOnError: a bad flag in -check mode must be UNKNOWN, not the exit code 2 = CRITICAL
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	var addresses addressListFlag
+	flags.Var(&addresses, "address", "NTP server address, repeat the flag or separate by comma to query several (default "+defaultAddress+")")
+	timeoutFlag := flags.Duration("timeout", 5*time.Second, "timeout per NTP query")
+	verboseFlag := flags.Bool("v", false, "print full diagnostics of every server")
+	formatFlag := flags.String("format", formatText, "output format: text or json")
+	watchFlag := flags.Duration("watch", 0, "keep polling with this interval (16s to 36h) and stream samples, 0 to query once")
+	csvFlag := flags.Bool("csv", true, "in -watch mode stream samples to stdout as CSV")
+	metricsFlag := flags.String("metrics", "", "in -watch mode serve Prometheus metrics on this address, example: :9123")
+	serveFlag := flags.String("serve", "", "run an SNTP server on this address instead of querying, example: :123")
+	upstreamFlag := flags.Bool("upstream", false, "in -serve mode correct the local clock by -address servers")
+	stratumFlag := flags.Uint("stratum", 0, "in -serve mode stratum to announce, 0 for 1 (local) or upstream+1")
+	refIDFlag := flags.String("refid", "", "in -serve mode reference id: 1-4 ASCII chars or IPv4 (default LOCL or upstream address)")
+	pollFlag := flags.Duration("poll", 64*time.Second, "in -serve -upstream and -sync modes interval between upstream polls")
+	ntsFlag := flags.Bool("nts", false, "authenticate with NTS (RFC 8915), every -address is then an NTS-KE server host[:port]")
+	ntsCAFlag := flags.String("nts-ca", "", "PEM file with CA certificates to verify NTS-KE servers (default system roots)")
+	requireAuthFlag := flags.Bool("require-auth", false, "treat unauthenticated responses as errors instead of falling back to plain NTP")
+	keysFlag := flags.String("keys", "", "ntpd-style ntp.keys file with symmetric keys, used by -key-id and by -serve")
+	keyIDFlag := flags.Uint("key-id", 0, "sign queries with this key from -keys and reject responses without a valid MAC")
+	var httpAddresses addressListFlag
+	flags.Var(&httpAddresses, "http", "HTTP(S) server URL or host to take the time from Date headers when no NTP server answers, repeatable")
+	checkFlag := flags.Bool("check", false, "monitoring plugin mode: print one status line with perfdata and exit 0/1/2/3 (OK/WARNING/CRITICAL/UNKNOWN)")
+	warnFlag := flags.Duration("warn", 100*time.Millisecond, "in -check mode absolute offset that gives WARNING")
+	critFlag := flags.Duration("crit", time.Second, "in -check mode absolute offset that gives CRITICAL")
+	warnStratumFlag := flags.Uint("warn-stratum", 0, "in -check mode stratum that gives WARNING, 0 to disable")
+	critStratumFlag := flags.Uint("crit-stratum", 0, "in -check mode stratum that gives CRITICAL, 0 to disable")
+	poolFlag := flags.Bool("pool", false, "resolve every -address into all of its A/AAAA records and query each IP on its own")
+	ipv4Flag := flags.Bool("4", false, "use IPv4 only")
+	ipv6Flag := flags.Bool("6", false, "use IPv6 only")
+	sourceFlag := flags.String("source", "", "send queries from this IP address or interface name")
+	ttlFlag := flags.Int("ttl", 0, "TTL (hop limit) of query packets, 0 for the system default")
+	syncFlag := flags.Bool("sync", false, "discipline the system clock every -poll: slew small offsets, step large ones (needs root)")
+	maxStepFlag := flags.Duration("max-step", 1000*time.Second, "in -sync mode never step the clock by more than this")
+	dryRunFlag := flags.Bool("dry-run", false, "in -sync mode only print what would be done to the clock")
+	var roughtimeServers addressListFlag
+	flags.Var(&roughtimeServers, "roughtime", "query this Roughtime server host[:port]=base64-public-key instead of NTP, repeat to chain several and catch a lying one")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			os.Exit(0)
+		case checkRequested(os.Args[1:]):
+			exitUnknown(err)
+		default:
+			os.Exit(2)
+		}
+	}
+
+	// in -check mode every failure must still be a plugin answer, log.Fatal would exit with 1 = WARNING
+	fatal := log.Fatal
+	if *checkFlag {
+		fatal = exitUnknown
+	}
+
+	if len(addresses) == 0 {
+		addresses = addressListFlag{defaultAddress}
+	}
+
+	format := *formatFlag
+	if format != formatText && format != formatJSON {
+		fatal(fmt.Sprintf("unknown -format %q, expected %s or %s", format, formatText, formatJSON))
+	}
+
+	if *ipv4Flag && *ipv6Flag {
+		fatal("-4 and -6 can't be used together")
+	}
+	if *ttlFlag < 0 || *ttlFlag > 255 {
+		fatal("-ttl must be between 0 and 255")
+	}
+	ipNetwork, udpNetwork := "ip", "udp"
+	if *ipv4Flag {
+		ipNetwork, udpNetwork = "ip4", "udp4"
+	} else if *ipv6Flag {
+		ipNetwork, udpNetwork = "ip6", "udp6"
+	}
+
+	options := ntp.QueryOptions{Timeout: *timeoutFlag, Dialer: ntpclock.Dialer(udpNetwork, *ttlFlag)}
+	if *sourceFlag != "" {
+		source, err := ntpclock.SourceAddress(*sourceFlag, ipNetwork)
+		if err != nil {
+			fatal(err)
+		}
+		options.LocalAddress = source
+	}
+
+	if *poolFlag {
+		if *ntsFlag {
+			fatal("-pool can't be used with -nts: NTS-KE servers are verified by their names")
+		}
+		resolved, err := ntpclock.ResolvePool(context.Background(), net.DefaultResolver, addresses, ipNetwork)
+		if err != nil {
+			fatal(err)
+		}
+		if format == formatText && !*checkFlag {
+			fmt.Printf("resolved %s: %s\n", strings.Join(addresses, ", "), strings.Join(resolved, ", "))
+		}
+		addresses = resolved
+	}
+
+	var keys map[uint16]ntpclock.SymmetricKey
+	if *keysFlag != "" {
+		var err error
+		keys, err = ntpclock.LoadKeyFile(*keysFlag)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	query := ntpclock.PlainQuery(options)
+	switch {
+	case *ntsFlag && *keyIDFlag != 0:
+		fatal("-nts and -key-id can't be used together")
+	case *ntsFlag:
+		tlsConfig, err := loadNTSTLSConfig(*ntsCAFlag)
+		if err != nil {
+			fatal(err)
+		}
+		query = ntpclock.NewNTSQuerier(options, tlsConfig, *requireAuthFlag).Query
+	case *keyIDFlag != 0:
+		// symmetric keys are always required: beevik/ntp rejects responses without a valid MAC
+		key, ok := keys[uint16(*keyIDFlag)]
+		if !ok || *keyIDFlag > 0xffff {
+			fatal(fmt.Sprintf("key %d is not found, check -keys", *keyIDFlag))
+		}
+		query = ntpclock.SymmetricQuery(options, key)
+	case *requireAuthFlag:
+		fatal("-require-auth needs an authentication method: -nts or -key-id")
+	}
+
+	if *checkFlag && (*serveFlag != "" || *watchFlag > 0 || *syncFlag || len(roughtimeServers) > 0) {
+		fatal("-check can't be used with -serve, -watch, -sync or -roughtime")
+	}
+	if *checkFlag && *warnFlag > *critFlag {
+		fatal("-warn must not be greater than -crit")
+	}
+
+	if len(roughtimeServers) > 0 {
+		servers, err := parseRoughtimeServers(roughtimeServers)
+		if err != nil {
+			fatal(err)
+		}
+		err = runRoughtime(servers, *timeoutFlag, format)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *syncFlag {
+		var adjuster ntpclock.IClockAdjuster = ntpclock.NewDryRunAdjuster(os.Stdout)
+		if !*dryRunFlag {
+			system, err := ntpclock.NewSystemAdjuster()
+			if err != nil {
+				fatal(err)
+			}
+			adjuster = system
+		}
+		poll := pollInterval(*pollFlag, "-poll")
+		discipline := ntpclock.NewDiscipline(adjuster, *maxStepFlag, poll)
+		err := runSync(addresses, ntpclock.NewPoller(poll).Wrap(query), discipline, poll)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *serveFlag != "" {
+		if *stratumFlag > 15 {
+			fatal("-stratum must be 0 (auto) or 1-15")
+		}
+		poll := pollInterval(*pollFlag, "-poll")
+		server, err := newServeServer(*upstreamFlag, addresses, ntpclock.NewPoller(poll).Wrap(query), uint8(*stratumFlag), *refIDFlag)
+		if err != nil {
+			fatal(err)
+		}
+		server.Keys = keys
+		err = runServe(*serveFlag, server, poll)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *watchFlag > 0 {
+		interval := pollInterval(*watchFlag, "-watch")
+		err := runWatch(addresses, ntpclock.NewPoller(interval).Wrap(query), interval, *csvFlag, *metricsFlag)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// -check prints nothing but its status line
+	progress := format == formatText && !*checkFlag
+	if progress {
+		fmt.Println("begin reading NTP:", strings.Join(addresses, ", "))
+	}
+
+	samples := ntpclock.QueryAll(addresses, query)
+
+	result, err := ntpclock.SelectTruechimers(samples)
+
+	// NTP is preferred, HTTP Date headers are only used when UDP/123 gives nothing at all
+	source := sourceNTP
+	if errors.Is(err, ntpclock.ErrNoCandidates) && len(httpAddresses) > 0 {
+		if progress {
+			if len(addresses) > 1 || *verboseFlag {
+				printSamples(samples, result)
+			}
+			fmt.Printf("no NTP server answered, falling back to HTTP Date headers: %s\n", strings.Join(httpAddresses, ", "))
+		}
+		source = sourceHTTPDate
+		samples = ntpclock.QueryAll(httpAddresses, ntpclock.HTTPDateQuery(*timeoutFlag, ntpclock.DefaultHTTPRounds))
+		result, err = ntpclock.SelectTruechimers(samples)
+	}
+
+	if *checkFlag {
+		exitCheck(evaluateCheck(samples, result, err, source, checkThresholds{
+			warnOffset:  *warnFlag,
+			critOffset:  *critFlag,
+			warnStratum: *warnStratumFlag,
+			critStratum: *critStratumFlag,
+		}))
+	}
+
+	if format == formatJSON || *verboseFlag {
+		report := buildReport(samples, result, err, time.Now())
+		report.Source = source
+		if format == formatJSON {
+			if writeErr := writeJSON(os.Stdout, report); writeErr != nil {
+				fatal(writeErr)
+			}
+		} else {
+			writeVerbose(os.Stdout, report)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if format == formatText {
+			fmt.Println(report.Time)
+		}
+		return
+	}
+
+	if len(samples) > 1 || err != nil {
+		printSamples(samples, result)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	if len(samples) > 1 {
+		fmt.Printf("consensus offset: %v ± %v (%d of %d servers)\n",
+			result.Offset, result.ErrorBound, len(result.Truechimers), len(samples))
+	}
+	if source == sourceNTP {
+		writeLeap(os.Stdout, buildLeapReport(samples, ntpclock.AnalyzeLeap(samples, time.Now())), len(samples))
+	}
+	fmt.Println("time source:", sourceString(source))
+	fmt.Println(time.Now().Add(result.Offset))
+}
+
+// pollInterval keeps a repeated poll interval within RFC 5905 limits, so public pools don't ban us
+func pollInterval(interval time.Duration, flagName string) time.Duration {
+	clamped := ntpclock.ClampPoll(interval)
+	if clamped != interval {
+		log.Printf("%s %v is outside of RFC 5905 poll limits, using %v", flagName, interval, clamped)
+	}
+	return clamped
+}
+
+// runWatch polls servers until SIGINT, streaming CSV to stdout and/or serving metrics over HTTP
+func runWatch(addresses []string, query ntpclock.QueryFunc, interval time.Duration, streamCSV bool, metricsAddress string) error {
+	var csvOut io.Writer
+	if streamCSV {
+		csvOut = os.Stdout
+	}
+	w := newWatcher(addresses, query, interval, csvOut)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", w)
+		server := &http.Server{Addr: metricsAddress, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+		go func() {
+			<-ctx.Done()
+			_ = server.Close()
+		}()
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println("metrics server stopped:", err)
+				stop()
+			}
+		}()
+	}
+
+	return w.run(ctx)
+}
+
+// newServeServer creates the SNTP server for -serve mode with the local or upstream-corrected clock
+func newServeServer(upstream bool, addresses []string, query ntpclock.QueryFunc, stratum uint8, refID string) (*ntpclock.Server, error) {
+	var clock ntpclock.IServerClock = ntpclock.NewLocalClock()
+	if upstream {
+		clock = ntpclock.NewClock(addresses, query)
+	}
+	server := ntpclock.NewServer(clock)
+	server.Stratum = stratum
+
+	if refID != "" {
+		referenceID, err := ntpclock.ParseReferenceID(refID)
+		if err != nil {
+			return nil, err
+		}
+		server.ReferenceID = referenceID
+	}
+	return server, nil
+}
+
+// runSync corrects the system clock by the consensus offset every poll until SIGINT
+//
+// polls without consensus are skipped, an offset over the maximum step stops the loop:
+// something is badly wrong and a human has to look
+func runSync(addresses []string, query ntpclock.QueryFunc, discipline *ntpclock.Discipline, poll time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		samples := ntpclock.QueryAll(addresses, query)
+		result, err := ntpclock.SelectTruechimers(samples)
+		if err != nil {
+			log.Println("no consensus, clock left alone:", err)
+		} else {
+			adjustment, err := discipline.Update(result.Offset, time.Now())
+			if err != nil {
+				return err
+			}
+			action := "slewed"
+			if adjustment.Stepped {
+				action = "stepped"
+			}
+			log.Printf("offset %v ± %v, %s, frequency %.3f ppm",
+				adjustment.Offset, result.ErrorBound, action, adjustment.FrequencyPPM)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
 
-	address := *addressFlag
-	fmt.Println("begin reading NTP:", address)
-	result, err := ntp.Time(address)
+// runRoughtime queries a chain of Roughtime servers and prints midpoints and radii of their verified answers
+func runRoughtime(servers []ntpclock.RoughtimeServer, timeout time.Duration, format string) error {
+	if format == formatText {
+		fmt.Println("begin reading Roughtime:", len(servers), "server(s)")
+	}
+
+	results, chainErr := ntpclock.QueryRoughtimeChain(servers, timeout)
+	report := buildRoughtimeReport(results, chainErr)
+	if format == formatJSON {
+		if err := writeJSON(os.Stdout, report); err != nil {
+			return err
+		}
+	} else {
+		writeRoughtime(os.Stdout, report)
+	}
+	if report.Error != "" {
+		return errors.New(report.Error)
+	}
+
+	if format == formatText {
+		// the last verified answer is the freshest one
+		for i := len(results) - 1; i >= 0; i-- {
+			if results[i].Err == nil {
+				fmt.Println("time source: roughtime", results[i].Server.Address)
+				fmt.Printf("%v ± %v\n", time.Now().Add(results[i].Offset), results[i].Radius)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// runServe answers SNTP requests on address until SIGINT
+func runServe(address string, server *ntpclock.Server, poll time.Duration) error {
+	conn, err := net.ListenPacket("udp", address)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if upstream, ok := server.Clock.(*ntpclock.Clock); ok {
+		go upstream.Run(ctx, poll)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	log.Println("serving SNTP on", conn.LocalAddr())
+	return server.Serve(conn)
+}
+
+// loadNTSTLSConfig returns TLS settings for NTS-KE, caFile may be empty to use system roots
+func loadNTSTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -nts-ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// printSamples prints one line per server: its offset and root distance, or why it was dropped
+func printSamples(samples []ntpclock.Sample, result ntpclock.Consensus) {
+	truechimers := make(map[int]struct{}, len(result.Truechimers))
+	for _, i := range result.Truechimers {
+		truechimers[i] = struct{}{}
+	}
+
+	for i, sample := range samples {
+		if sample.Err != nil {
+			fmt.Printf("  %s: error: %v\n", sample.Address, sample.Err)
+			continue
+		}
+
+		status := "falseticker"
+		if _, ok := truechimers[i]; ok {
+			status = "truechimer"
+		}
+		if sample.Auth != "" {
+			status += ", authenticated by " + sample.Auth
+		}
+		fmt.Printf("  %s: offset %v, root distance %v, %s\n",
+			sample.Address, sample.Response.ClockOffset, sample.Response.RootDistance, status)
 	}
-	fmt.Println(result)
 }
@@ -0,0 +1,49 @@
+package ntpclock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrAdjustUnsupported is returned by NewSystemAdjuster on systems without adjtimex
+var ErrAdjustUnsupported = errors.New("adjusting the system clock is not supported on this system")
+
+// IClockAdjuster corrects the clock that a Discipline keeps in time
+type IClockAdjuster interface {
+	// Step jumps the clock by offset at once
+	Step(offset time.Duration) error
+	// Slew makes the clock catch up offset gradually, without jumps
+	Slew(offset time.Duration) error
+	// SetFrequency sets the frequency correction in ppm, positive makes the clock run faster
+	SetFrequency(ppm float64) error
+}
+
+// DryRunAdjuster only reports what it would do
+type DryRunAdjuster struct {
+	out io.Writer
+}
+
+// NewDryRunAdjuster creates an adjuster that writes its actions to out instead of touching the clock
+func NewDryRunAdjuster(out io.Writer) *DryRunAdjuster {
+	return &DryRunAdjuster{out: out}
+}
+
+// Step implements IClockAdjuster
+func (a *DryRunAdjuster) Step(offset time.Duration) error {
+	_, err := fmt.Fprintf(a.out, "dry run: would step the clock by %v\n", offset)
+	return err
+}
+
+// Slew implements IClockAdjuster
+func (a *DryRunAdjuster) Slew(offset time.Duration) error {
+	_, err := fmt.Fprintf(a.out, "dry run: would slew the clock by %v\n", offset)
+	return err
+}
+
+// SetFrequency implements IClockAdjuster
+func (a *DryRunAdjuster) SetFrequency(ppm float64) error {
+	_, err := fmt.Fprintf(a.out, "dry run: would set frequency correction to %.3f ppm\n", ppm)
+	return err
+}
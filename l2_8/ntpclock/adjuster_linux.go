@@ -0,0 +1,61 @@
+package ntpclock
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSingleShotSlew is the largest offset adjtime-style slewing accepts
+const maxSingleShotSlew = 500 * time.Millisecond
+
+// kernelFrequencyScale converts ppm to the fixed point frequency of struct timex (16 fractional bits)
+const kernelFrequencyScale = 1 << 16
+
+// SystemAdjuster corrects the system clock with adjtimex(2), it needs CAP_SYS_TIME
+type SystemAdjuster struct{}
+
+// NewSystemAdjuster returns the system clock adjuster
+func NewSystemAdjuster() (*SystemAdjuster, error) {
+	return &SystemAdjuster{}, nil
+}
+
+// Step implements IClockAdjuster with ADJ_SETOFFSET
+func (a *SystemAdjuster) Step(offset time.Duration) error {
+	tx := unix.Timex{
+		Modes: unix.ADJ_SETOFFSET,
+		// NsecToTimeval keeps the microseconds non-negative, as the kernel wants
+		Time: unix.NsecToTimeval(offset.Nanoseconds()),
+	}
+	if _, err := unix.Adjtimex(&tx); err != nil {
+		return fmt.Errorf("failed to step the clock: %w", err)
+	}
+	return nil
+}
+
+// Slew implements IClockAdjuster with ADJ_OFFSET_SINGLESHOT, the kernel slews at 500 ppm
+func (a *SystemAdjuster) Slew(offset time.Duration) error {
+	offset = min(max(offset, -maxSingleShotSlew), maxSingleShotSlew)
+	tx := unix.Timex{Modes: unix.ADJ_OFFSET_SINGLESHOT}
+	setTimexField(&tx.Offset, offset.Microseconds())
+	if _, err := unix.Adjtimex(&tx); err != nil {
+		return fmt.Errorf("failed to slew the clock: %w", err)
+	}
+	return nil
+}
+
+// SetFrequency implements IClockAdjuster with ADJ_FREQUENCY
+func (a *SystemAdjuster) SetFrequency(ppm float64) error {
+	tx := unix.Timex{Modes: unix.ADJ_FREQUENCY}
+	setTimexField(&tx.Freq, int64(ppm*kernelFrequencyScale))
+	if _, err := unix.Adjtimex(&tx); err != nil {
+		return fmt.Errorf("failed to set the clock frequency: %w", err)
+	}
+	return nil
+}
+
+// setTimexField sets a struct timex field, they are 32 or 64 bit depending on the architecture
+func setTimexField[T int32 | int64](field *T, value int64) {
+	*field = T(value)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package ntpclock
+
+import "time"
+
+// SystemAdjuster corrects the system clock, only Linux is supported
+type SystemAdjuster struct{}
+
+// NewSystemAdjuster returns ErrAdjustUnsupported outside of Linux
+func NewSystemAdjuster() (*SystemAdjuster, error) {
+	return nil, ErrAdjustUnsupported
+}
+
+// Step implements IClockAdjuster
+func (a *SystemAdjuster) Step(time.Duration) error { return ErrAdjustUnsupported }
+
+// Slew implements IClockAdjuster
+func (a *SystemAdjuster) Slew(time.Duration) error { return ErrAdjustUnsupported }
+
+// SetFrequency implements IClockAdjuster
+func (a *SystemAdjuster) SetFrequency(float64) error { return ErrAdjustUnsupported }
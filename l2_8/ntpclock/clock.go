@@ -0,0 +1,171 @@
+package ntpclock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Status describes how much the corrected time of a Clock can be trusted
+type Status struct {
+	// Synced is false until the first successful refresh
+	Synced bool
+	// Offset is added to the local clock, "server - local"
+	Offset time.Duration
+	// Uncertainty is the error bound of the last consensus grown by PHI since the last sync
+	Uncertainty time.Duration
+	// Staleness is the time since the last successful refresh
+	Staleness time.Duration
+	// LastSync is the local time of the last successful refresh
+	LastSync time.Time
+	// LastError is the error of the last refresh, nil if it succeeded
+	LastError error
+}
+
+// Clock is the local clock corrected by the consensus offset of NTP servers
+//
+// it is safe for concurrent use. Until the first successful refresh Now returns the local time
+// and, as a server clock, it reports itself unsynchronized (leap 3, stratum 16),
+// so well-behaved clients won't use it
+type Clock struct {
+	addresses []string
+	query     QueryFunc
+
+	mu         sync.RWMutex
+	synced     bool
+	offset     time.Duration
+	errorBound time.Duration
+	lastSync   time.Time
+	lastErr    error
+	state      ClockState
+}
+
+// NewClock creates a clock corrected by addresses, call Refresh, Run or Start to synchronize it
+//
+// nil query means PlainQuery with default options
+func NewClock(addresses []string, query QueryFunc) *Clock {
+	if query == nil {
+		query = PlainQuery(ntp.QueryOptions{})
+	}
+	return &Clock{addresses: addresses, query: query}
+}
+
+// Start refreshes the clock in the background every interval, stop waits for the loop to exit
+func (c *Clock) Start(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, interval)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Run refreshes the clock every interval until ctx is done
+func (c *Clock) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = c.Refresh()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh queries the servers once, a failed refresh keeps the previous offset
+func (c *Clock) Refresh() error {
+	samples := QueryAll(c.addresses, c.query)
+	result, err := SelectTruechimers(samples)
+	if err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+
+	// the best truechimer (smallest root distance) becomes our system peer
+	best := samples[result.Truechimers[0]]
+	for _, i := range result.Truechimers[1:] {
+		if samples[i].Response.RootDistance < best.Response.RootDistance {
+			best = samples[i]
+		}
+	}
+
+	now := time.Now()
+	state := ClockState{
+		Leap:           best.Response.Leap,
+		Stratum:        best.Response.Stratum + 1,
+		ReferenceID:    addressReferenceID(best.Address),
+		ReferenceTime:  now.Add(result.Offset),
+		RootDelay:      best.Response.RootDelay + best.Response.RTT,
+		RootDispersion: best.Response.RootDispersion + result.ErrorBound,
+	}
+
+	c.mu.Lock()
+	c.synced = true
+	c.offset = result.Offset
+	c.errorBound = result.ErrorBound
+	c.lastSync = now
+	c.lastErr = nil
+	c.state = state
+	c.mu.Unlock()
+	return nil
+}
+
+// Now returns the corrected time, or the local time if the clock has never been synchronized
+func (c *Clock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Now().Add(c.offset)
+}
+
+// Since is like time.Since but measured against the corrected time
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Status returns the current offset and how much it can be trusted
+func (c *Clock) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := Status{
+		Synced:    c.synced,
+		Offset:    c.offset,
+		LastSync:  c.lastSync,
+		LastError: c.lastErr,
+	}
+	if c.synced {
+		status.Staleness = time.Since(c.lastSync)
+		status.Uncertainty = c.errorBound + dispersionSince(status.Staleness)
+	}
+	return status
+}
+
+// ServerTime implements IServerClock, dispersion grows with the age of the last sync
+func (c *Clock) ServerTime() (time.Time, ClockState) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	if !c.synced {
+		return now, ClockState{Leap: ntp.LeapNotInSync, Stratum: 16}
+	}
+
+	state := c.state
+	state.RootDispersion += dispersionSince(now.Sub(c.lastSync))
+	return now.Add(c.offset), state
+}
+
+// dispersionSince is the error a clock accumulates over age at the frequency tolerance PHI
+func dispersionSince(age time.Duration) time.Duration {
+	return time.Duration(age.Seconds() * phi * float64(time.Second))
+}
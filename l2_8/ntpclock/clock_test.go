@@ -0,0 +1,74 @@
+package ntpclock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestClockNow(t *testing.T) {
+	address := startFakeNTP(t, fakeResponder{offset: 2 * time.Second})
+	clock := NewClock([]string{address}, PlainQuery(ntp.QueryOptions{Timeout: time.Second}))
+
+	if diff := clock.Now().Sub(time.Now()); diff > 50*time.Millisecond || diff < -50*time.Millisecond {
+		t.Errorf("unsynchronized clock must return local time, differs by %v", diff)
+	}
+	if status := clock.Status(); status.Synced || status.Uncertainty != 0 {
+		t.Errorf("unexpected status before refresh: %+v", status)
+	}
+
+	if err := clock.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if diff := clock.Now().Sub(time.Now()) - 2*time.Second; diff > 50*time.Millisecond || diff < -50*time.Millisecond {
+		t.Errorf("expected corrected time about 2s ahead, off by %v", diff)
+	}
+
+	status := clock.Status()
+	if !status.Synced || status.LastError != nil {
+		t.Fatalf("unexpected status after refresh: %+v", status)
+	}
+	if status.Uncertainty < minDispersion {
+		t.Errorf("uncertainty %v must be at least the consensus error bound", status.Uncertainty)
+	}
+	if status.Staleness < 0 || status.Staleness > time.Second {
+		t.Errorf("unexpected staleness %v", status.Staleness)
+	}
+}
+
+func TestClockFailedRefreshKeepsOffset(t *testing.T) {
+	good := startFakeNTP(t, fakeResponder{offset: time.Second})
+	failing := false
+	query := func(address string) Sample {
+		if failing {
+			return Sample{Address: address, Err: errors.New("timeout")}
+		}
+		return PlainQuery(ntp.QueryOptions{Timeout: time.Second})(address)
+	}
+	clock := NewClock([]string{good}, query)
+
+	if err := clock.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	failing = true
+	if err := clock.Refresh(); err == nil {
+		t.Fatal("expected refresh error")
+	}
+
+	status := clock.Status()
+	if !status.Synced || status.LastError == nil {
+		t.Errorf("expected synced clock with last error, got %+v", status)
+	}
+	if diff := status.Offset - time.Second; diff > 50*time.Millisecond || diff < -50*time.Millisecond {
+		t.Errorf("failed refresh must keep the offset, got %v", status.Offset)
+	}
+}
+
+func TestDispersionSince(t *testing.T) {
+	if d := dispersionSince(1000 * time.Second); d != 15*time.Millisecond {
+		t.Errorf("expected 15ms after 1000s, got %v", d)
+	}
+}
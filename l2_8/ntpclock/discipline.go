@@ -0,0 +1,103 @@
+package ntpclock
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// discipline constants, the same ntpd uses
+const (
+	// stepThreshold is STEPT from RFC 5905: larger offsets are stepped, smaller are slewed
+	stepThreshold = 128 * time.Millisecond
+	// maxFrequencyPPM is the largest frequency correction the kernel accepts
+	maxFrequencyPPM = 500
+	// fllAverage is how many updates the FLL averages the frequency error over
+	fllAverage = 4
+	// pllTimeConstantPolls is the PLL time constant in poll intervals
+	pllTimeConstantPolls = 4
+)
+
+// ErrStepTooLarge is returned when the offset is more than the maximum step, the clock is left alone
+var ErrStepTooLarge = errors.New("offset is larger than the maximum step")
+
+// Adjustment is what one Discipline update did
+type Adjustment struct {
+	// Stepped is true if the clock jumped, false if it was slewed
+	Stepped bool
+	// Offset is the measured offset that was corrected
+	Offset time.Duration
+	// FrequencyPPM is the frequency correction in effect after the update
+	FrequencyPPM float64
+}
+
+// Discipline keeps a clock in time with measured offsets, like the ntpd clock discipline
+//
+// offsets larger than 128ms are stepped, up to the maximum step. Smaller ones are slewed,
+// and the frequency is corrected so that the clock stops drifting away between updates.
+// The frequency correction is a PLL/FLL hybrid: the PLL term offset·µ/τ² follows the phase,
+// the FLL term offset/µ (averaged over 4 updates) measures the frequency error directly,
+// where µ is the time since the previous update and τ is 4 poll intervals
+type Discipline struct {
+	adjuster     IClockAdjuster
+	maxStep      time.Duration
+	timeConstant time.Duration
+
+	frequency  float64
+	lastUpdate time.Time
+}
+
+// NewDiscipline creates a discipline for the clock of adjuster updated every poll interval
+func NewDiscipline(adjuster IClockAdjuster, maxStep time.Duration, poll time.Duration) *Discipline {
+	return &Discipline{
+		adjuster:     adjuster,
+		maxStep:      maxStep,
+		timeConstant: pllTimeConstantPolls * poll,
+	}
+}
+
+// Update corrects the clock by offset ("server - local") measured at now
+func (d *Discipline) Update(offset time.Duration, now time.Time) (Adjustment, error) {
+	adjustment := Adjustment{Offset: offset, FrequencyPPM: d.frequency}
+
+	magnitude := offset.Abs()
+	if magnitude > d.maxStep {
+		return adjustment, fmt.Errorf("%w: %v > %v", ErrStepTooLarge, offset, d.maxStep)
+	}
+
+	if magnitude > stepThreshold {
+		if err := d.adjuster.Step(offset); err != nil {
+			return adjustment, err
+		}
+		// the offset before the step says nothing about the frequency
+		d.lastUpdate = now
+		adjustment.Stepped = true
+		return adjustment, nil
+	}
+
+	if !d.lastUpdate.IsZero() {
+		mu := now.Sub(d.lastUpdate).Seconds()
+		if mu > 0 {
+			tau := d.timeConstant.Seconds()
+			pll := offset.Seconds() * mu / (tau * tau)
+			fll := offset.Seconds() / mu / fllAverage
+			d.frequency += (pll + fll) * 1e6
+			d.frequency = min(max(d.frequency, -maxFrequencyPPM), maxFrequencyPPM)
+		}
+		if err := d.adjuster.SetFrequency(d.frequency); err != nil {
+			return adjustment, err
+		}
+	}
+	d.lastUpdate = now
+
+	if err := d.adjuster.Slew(offset); err != nil {
+		return adjustment, err
+	}
+	adjustment.FrequencyPPM = d.frequency
+	return adjustment, nil
+}
+
+// Frequency returns the current frequency correction in ppm
+func (d *Discipline) Frequency() float64 {
+	return d.frequency
+}
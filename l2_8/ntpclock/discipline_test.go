@@ -0,0 +1,107 @@
+package ntpclock
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAdjustedClock is a simulated local clock with its own frequency error, corrected by a Discipline
+type fakeAdjustedClock struct {
+	// driftPPM is how much the clock runs fast on its own
+	driftPPM float64
+	// offset is "server - local"
+	offset time.Duration
+
+	frequencyPPM float64
+	steps        int
+	slews        int
+}
+
+// advance lets the clock run for d, it gains (drift + correction) ppm on the true time
+func (c *fakeAdjustedClock) advance(d time.Duration) {
+	gain := (c.driftPPM + c.frequencyPPM) * 1e-6 * d.Seconds()
+	c.offset -= time.Duration(gain * float64(time.Second))
+}
+
+func (c *fakeAdjustedClock) Step(offset time.Duration) error {
+	c.steps++
+	c.offset -= offset
+	return nil
+}
+
+func (c *fakeAdjustedClock) Slew(offset time.Duration) error {
+	c.slews++
+	c.offset -= offset
+	return nil
+}
+
+func (c *fakeAdjustedClock) SetFrequency(ppm float64) error {
+	c.frequencyPPM = ppm
+	return nil
+}
+
+func TestDisciplineConverges(t *testing.T) {
+	const poll = 64 * time.Second
+	clock := &fakeAdjustedClock{driftPPM: 50, offset: 3 * time.Second}
+	discipline := NewDiscipline(clock, time.Minute, poll)
+
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for range 100 {
+		if _, err := discipline.Update(clock.offset, now); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		clock.advance(poll)
+		now = now.Add(poll)
+	}
+
+	if clock.steps != 1 {
+		t.Errorf("expected the initial 3s offset to be stepped once, got %d steps", clock.steps)
+	}
+	if math.Abs(clock.frequencyPPM+50) > 0.5 {
+		t.Errorf("expected frequency correction of about -50 ppm, got %.3f", clock.frequencyPPM)
+	}
+	if clock.offset.Abs() > 100*time.Microsecond {
+		t.Errorf("expected the clock to stay within 100µs, got %v", clock.offset)
+	}
+}
+
+func TestDisciplineMaxStep(t *testing.T) {
+	clock := &fakeAdjustedClock{}
+	discipline := NewDiscipline(clock, time.Second, MinPoll)
+
+	_, err := discipline.Update(-5*time.Second, time.Now())
+	if !errors.Is(err, ErrStepTooLarge) {
+		t.Fatalf("expected ErrStepTooLarge, got %v", err)
+	}
+	if clock.steps != 0 || clock.slews != 0 || clock.frequencyPPM != 0 {
+		t.Errorf("the clock must be left alone: %+v", clock)
+	}
+
+	adjustment, err := discipline.Update(500*time.Millisecond, time.Now())
+	if err != nil || !adjustment.Stepped || clock.steps != 1 {
+		t.Errorf("expected a step within the limit, got %+v, %v", adjustment, err)
+	}
+}
+
+func TestDryRunAdjuster(t *testing.T) {
+	var out bytes.Buffer
+	discipline := NewDiscipline(NewDryRunAdjuster(&out), time.Minute, MinPoll)
+
+	now := time.Now()
+	if _, err := discipline.Update(2*time.Second, now); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := discipline.Update(10*time.Millisecond, now.Add(MinPoll)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "step the clock by 2s") ||
+		!strings.Contains(lines[1], "frequency correction") || !strings.Contains(lines[2], "slew the clock by 10ms") {
+		t.Errorf("unexpected dry run output:\n%s", out.String())
+	}
+}
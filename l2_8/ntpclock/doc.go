@@ -0,0 +1,12 @@
+// Package ntpclock keeps a local clock corrected by a consensus of NTP servers
+//
+// Clock is the entry point for other programs: it polls servers in the background and
+// Now returns the corrected time, Status tells how stale and uncertain it is.
+// The lower-level pieces (QueryAll, SelectTruechimers, NTS and symmetric-key queries,
+// the SNTP Server) are exported too, the l2_8 command is built on top of them.
+//
+//	clock := ntpclock.NewClock([]string{"time.google.com"}, nil)
+//	stop := clock.Start(time.Minute)
+//	defer stop()
+//	fmt.Println(clock.Now(), clock.Status().Uncertainty)
+package ntpclock
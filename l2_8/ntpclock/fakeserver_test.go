@@ -0,0 +1,82 @@
+package ntpclock
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// fakeResponder is a test clock: the local clock shifted by offset
+type fakeResponder struct {
+	// offset is added to the local clock before answering
+	offset time.Duration
+	// stratum defaults to 2
+	stratum uint8
+	// rootDispersion widens the correctness interval of the server
+	rootDispersion time.Duration
+	// leap is announced in every response
+	leap ntp.LeapIndicator
+	// kiss turns every response into a kiss of death with this code
+	kiss string
+}
+
+// ServerTime implements IServerClock
+func (f fakeResponder) ServerTime() (time.Time, ClockState) {
+	now := time.Now().Add(f.offset)
+	if f.kiss != "" {
+		code, _ := ParseReferenceID(f.kiss)
+		return now, ClockState{Stratum: 0, ReferenceID: code}
+	}
+	return now, ClockState{
+		Leap:           f.leap,
+		Stratum:        f.stratum,
+		ReferenceID:    0x7f000001,
+		ReferenceTime:  now.Add(-time.Second),
+		RootDispersion: f.rootDispersion,
+	}
+}
+
+// startFakeNTP starts a loopback SNTP server with the given clock and returns its address
+func startFakeNTP(t *testing.T, responder fakeResponder) string {
+	t.Helper()
+	return startFakeNTPAt(t, "127.0.0.1:0", responder)
+}
+
+// startFakeNTPAt is startFakeNTP on a given udp address
+func startFakeNTPAt(t *testing.T, listenAddress string, responder fakeResponder) string {
+	t.Helper()
+
+	if responder.stratum == 0 && responder.kiss == "" {
+		responder.stratum = 2
+	}
+	return serveOn(t, listenAddress, NewServer(responder))
+}
+
+// startServer serves clock on a random loopback port until the test ends
+func startServer(t *testing.T, clock IServerClock) string {
+	t.Helper()
+	return serveOnLoopback(t, NewServer(clock))
+}
+
+// serveOnLoopback runs a configured server on a random loopback port until the test ends
+func serveOnLoopback(t *testing.T, server *Server) string {
+	t.Helper()
+	return serveOn(t, "127.0.0.1:0", server)
+}
+
+// serveOn runs a configured server on the given udp address until the test ends
+func serveOn(t *testing.T, listenAddress string, server *Server) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", listenAddress)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() { _ = server.Serve(conn) }()
+
+	return conn.LocalAddr().String()
+}
@@ -0,0 +1,146 @@
+package ntpclock
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// DefaultHTTPRounds is the number of requests per server, every round after the first halves the interval
+const DefaultHTTPRounds = 8
+
+// ErrNoDateHeader is returned when an HTTP server doesn't send a usable Date header
+var ErrNoDateHeader = errors.New("no Date header in response")
+
+// ErrInconsistentDate is returned when Date headers of one server contradict each other
+var ErrInconsistentDate = errors.New("inconsistent Date headers")
+
+// httpDateQuerier estimates the clock offset of HTTP servers from their Date headers (htpdate style)
+//
+// Date has a resolution of one second, so one response only tells that the offset lies in
+// [Date - received, Date + 1s - sent]. The next request is timed so that it reaches the server
+// exactly when its clock turns a second under the midpoint of the current interval:
+// the second in the answer tells on which side of the midpoint the true offset is,
+// so every round halves the interval until it is about as narrow as the round trip.
+type httpDateQuerier struct {
+	client *http.Client
+	rounds int
+
+	// now and sleep are the local clock, replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// HTTPDateQuery returns a QueryFunc that takes the time from Date headers of HTTP(S) servers
+//
+// address is a URL or a bare host, which means https://host/. The samples it returns carry
+// a synthetic ntp.Response: ClockOffset is the middle of the interval and RootDistance is its width,
+// so they go through SelectTruechimers the same way NTP samples do
+func HTTPDateQuery(timeout time.Duration, rounds int) QueryFunc {
+	if rounds <= 0 {
+		rounds = DefaultHTTPRounds
+	}
+	q := &httpDateQuerier{
+		client: &http.Client{
+			Timeout: timeout,
+			// redirects carry the Date header too, following them only adds round trips
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		rounds: rounds,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+	return q.query
+}
+
+// query implements QueryFunc
+func (q *httpDateQuerier) query(address string) Sample {
+	sample := Sample{Address: address}
+	url := httpDateURL(address)
+
+	var low, high, minRTT time.Duration
+	var lastReceived time.Time
+	for round := 0; round < q.rounds; round++ {
+		if round > 0 {
+			// aim the server's second boundary under the midpoint guess at the middle of the request
+			guess := (low + high) / 2
+			target := q.now().Add(guess + minRTT).Truncate(time.Second).Add(time.Second)
+			wait := target.Add(-guess).Add(-minRTT / 2).Sub(q.now())
+			if wait > 0 {
+				q.sleep(wait)
+			}
+		}
+
+		sent, received, date, err := q.headDate(url)
+		if err != nil {
+			sample.Err = err
+			return sample
+		}
+
+		rtt := received.Sub(sent)
+		sampleLow, sampleHigh := date.Sub(received), date.Add(time.Second).Sub(sent)
+		if round == 0 {
+			low, high, minRTT = sampleLow, sampleHigh, rtt
+		} else {
+			low, high = max(low, sampleLow), min(high, sampleHigh)
+			minRTT = min(minRTT, rtt)
+		}
+		if low > high {
+			sample.Err = fmt.Errorf("%w: %s", ErrInconsistentDate, address)
+			return sample
+		}
+		lastReceived = received
+	}
+
+	// the true offset is within half the width from the middle, but the full width is reported:
+	// the middle of every honest server must stay inside the intervals of the others for selection
+	offset := (low + high) / 2
+	sample.Response = &ntp.Response{
+		Time:         lastReceived.Add(offset),
+		ClockOffset:  offset,
+		RTT:          minRTT,
+		RootDistance: high - low,
+		Leap:         ntp.LeapNoWarning,
+	}
+	return sample
+}
+
+// headDate sends one HEAD request and returns the local send and receive times with the server Date
+func (q *httpDateQuerier) headDate(url string) (sent, received, date time.Time, err error) {
+	request, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return sent, received, date, err
+	}
+	// caches would give us a stale Date
+	request.Header.Set("Cache-Control", "no-cache")
+
+	sent = q.now()
+	response, err := q.client.Do(request)
+	received = q.now()
+	if err != nil {
+		return sent, received, date, err
+	}
+	_ = response.Body.Close()
+
+	header := response.Header.Get("Date")
+	if header == "" {
+		return sent, received, date, fmt.Errorf("%w: %s", ErrNoDateHeader, url)
+	}
+	date, err = http.ParseTime(header)
+	if err != nil {
+		return sent, received, date, fmt.Errorf("%w: %s: %w", ErrNoDateHeader, url, err)
+	}
+	return sent, received, date, nil
+}
+
+// httpDateURL turns a bare host into an https URL
+func httpDateURL(address string) string {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return address
+	}
+	return "https://" + address + "/"
+}
@@ -0,0 +1,115 @@
+package ntpclock
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLocalClock is the real clock that jumps forward instead of sleeping, so rounds don't take seconds
+type fakeLocalClock struct {
+	mu      sync.Mutex
+	skipped time.Duration
+}
+
+func (c *fakeLocalClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.skipped)
+}
+
+func (c *fakeLocalClock) sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skipped += d
+}
+
+// startDateServer serves HEAD requests with the Date header of a clock that is offset ahead of local
+func startDateServer(t *testing.T, local *fakeLocalClock, offset time.Duration) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", local.now().Add(offset).UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// newTestHTTPDateQuerier creates a querier that runs on the fake local clock
+func newTestHTTPDateQuerier(local *fakeLocalClock, rounds int) *httpDateQuerier {
+	return &httpDateQuerier{
+		client: &http.Client{Timeout: time.Second},
+		rounds: rounds,
+		now:    local.now,
+		sleep:  local.sleep,
+	}
+}
+
+func TestHTTPDateSubSecond(t *testing.T) {
+	local := &fakeLocalClock{}
+	offsets := []time.Duration{2300 * time.Millisecond, -700 * time.Millisecond, 40 * time.Millisecond}
+
+	for _, offset := range offsets {
+		address := startDateServer(t, local, offset)
+		sample := newTestHTTPDateQuerier(local, 10).query(address)
+		if sample.Err != nil {
+			t.Fatalf("offset %v: %v", offset, sample.Err)
+		}
+
+		response := sample.Response
+		if diff := response.ClockOffset - offset; diff > 20*time.Millisecond || diff < -20*time.Millisecond {
+			t.Errorf("offset %v: estimated %v", offset, response.ClockOffset)
+		}
+		if response.RootDistance > 20*time.Millisecond {
+			t.Errorf("offset %v: 10 rounds must narrow the interval to less than 20ms, got ±%v", offset, response.RootDistance)
+		}
+	}
+}
+
+func TestHTTPDateCombinesServers(t *testing.T) {
+	offsets := []time.Duration{time.Second, time.Second, -time.Hour}
+
+	// every server gets its own fake clock: jumps of one querier must not shift the others mid-request
+	samples := make([]Sample, len(offsets))
+	for i, offset := range offsets {
+		local := &fakeLocalClock{}
+		samples[i] = newTestHTTPDateQuerier(local, 8).query(startDateServer(t, local, offset))
+	}
+	result, err := SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("selection: %v", err)
+	}
+	if len(result.Truechimers) != 2 || len(result.Falsetickers) != 1 || result.Falsetickers[0] != 2 {
+		t.Errorf("expected the hour-off server to be the falseticker: %+v", result)
+	}
+	if diff := result.Offset - time.Second; diff > 30*time.Millisecond || diff < -30*time.Millisecond {
+		t.Errorf("expected offset of about 1s, got %v", result.Offset)
+	}
+}
+
+func TestHTTPDateNoHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	t.Cleanup(server.Close)
+
+	sample := newTestHTTPDateQuerier(&fakeLocalClock{}, 2).query(server.URL)
+	if !errors.Is(sample.Err, ErrNoDateHeader) {
+		t.Errorf("expected ErrNoDateHeader, got %v", sample.Err)
+	}
+}
+
+func TestHTTPDateURL(t *testing.T) {
+	cases := map[string]string{
+		"example.com":            "https://example.com/",
+		"http://example.com/x":   "http://example.com/x",
+		"https://example.com:81": "https://example.com:81",
+	}
+	for address, expected := range cases {
+		if result := httpDateURL(address); result != expected {
+			t.Errorf("httpDateURL(%q): expected %q, got %q", address, expected, result)
+		}
+	}
+}
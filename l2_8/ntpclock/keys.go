@@ -0,0 +1,195 @@
+package ntpclock
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/ntp"
+)
+
+// ErrSymmetricAuthFailed is returned when a response MAC doesn't verify with the selected key
+var ErrSymmetricAuthFailed = errors.New("symmetric key authentication failed")
+
+// SymmetricKey is one line of an ntpd-style ntp.keys file
+type SymmetricKey struct {
+	ID       uint16
+	Type     ntp.AuthType
+	TypeName string
+	Key      []byte
+}
+
+// keyTypes maps key type names used by ntpd and chrony to beevik/ntp types
+var keyTypes = map[string]ntp.AuthType{
+	"M":            ntp.AuthMD5,
+	"MD5":          ntp.AuthMD5,
+	"SHA1":         ntp.AuthSHA1,
+	"SHA256":       ntp.AuthSHA256,
+	"SHA512":       ntp.AuthSHA512,
+	"AES128CMAC":   ntp.AuthAES128,
+	"AES-128-CMAC": ntp.AuthAES128,
+	"AES128":       ntp.AuthAES128,
+	"AES256CMAC":   ntp.AuthAES256,
+	"AES-256-CMAC": ntp.AuthAES256,
+	"AES256":       ntp.AuthAES256,
+}
+
+// authOptions returns options that make beevik/ntp sign queries and verify responses with the key
+func (k SymmetricKey) authOptions() ntp.AuthOptions {
+	return ntp.AuthOptions{
+		Type:  k.Type,
+		Key:   "HEX:" + hex.EncodeToString(k.Key),
+		KeyID: k.ID,
+	}
+}
+
+// String describes the key for output, example: "key 5 (SHA1)"
+func (k SymmetricKey) String() string {
+	return fmt.Sprintf("key %d (%s)", k.ID, k.TypeName)
+}
+
+// digest calculates the MAC digest of payload the same way beevik/ntp does
+//
+// hashes are calculated over key || payload, SHA-2 digests are truncated to 20 bytes to fit the NTP MAC field
+func (k SymmetricKey) digest(payload []byte) []byte {
+	data := append(append([]byte(nil), k.Key...), payload...)
+	switch k.Type {
+	case ntp.AuthMD5:
+		sum := md5.Sum(data)
+		return sum[:]
+	case ntp.AuthSHA1:
+		sum := sha1.Sum(data)
+		return sum[:]
+	case ntp.AuthSHA256:
+		sum := sha256.Sum256(data)
+		return sum[:20]
+	case ntp.AuthSHA512:
+		sum := sha512.Sum512(data)
+		return sum[:20]
+	default:
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil
+		}
+		sum := cmac(block, payload)
+		return sum[:]
+	}
+}
+
+// ParseKeyFile reads "keyid type key" lines, "#" starts a comment
+//
+// as in ntpd, a key of up to 20 characters is ASCII and a longer key is hex
+func ParseKeyFile(r io.Reader) (map[uint16]SymmetricKey, error) {
+	keys := make(map[uint16]SymmetricKey)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected \"keyid type key\"", lineNumber)
+		}
+
+		id, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("line %d: key id must be 1-65535, got %q", lineNumber, fields[0])
+		}
+
+		typeName := strings.ToUpper(fields[1])
+		authType, ok := keyTypes[typeName]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unsupported key type %q", lineNumber, fields[1])
+		}
+
+		key, err := decodeKey(fields[2], authType)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		keys[uint16(id)] = SymmetricKey{ID: uint16(id), Type: authType, TypeName: typeName, Key: key}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// decodeKey decodes the key column and checks its size for the type
+func decodeKey(s string, authType ntp.AuthType) ([]byte, error) {
+	key := []byte(s)
+	if len(s) > 20 {
+		var err error
+		key, err = hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("key longer than 20 characters must be hex: %w", err)
+		}
+	}
+
+	switch authType {
+	case ntp.AuthAES128:
+		if len(key) != 16 {
+			return nil, fmt.Errorf("AES-128-CMAC key must be 16 bytes, got %d", len(key))
+		}
+	case ntp.AuthAES256:
+		if len(key) != 32 {
+			return nil, fmt.Errorf("AES-256-CMAC key must be 32 bytes, got %d", len(key))
+		}
+	default:
+		if len(key) < 4 {
+			return nil, fmt.Errorf("key must be at least 4 bytes, got %d", len(key))
+		}
+		// beevik/ntp uses at most 32 bytes of a digest key
+		if len(key) > 32 {
+			key = key[:32]
+		}
+	}
+	return key, nil
+}
+
+// LoadKeyFile reads and parses the key file at path
+func LoadKeyFile(path string) (map[uint16]SymmetricKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer file.Close()
+
+	keys, err := ParseKeyFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// SymmetricQuery returns a QueryFunc that signs queries with key and rejects responses without a valid MAC
+func SymmetricQuery(options ntp.QueryOptions, key SymmetricKey) QueryFunc {
+	options.Auth = key.authOptions()
+	return func(address string) Sample {
+		sample := queryOne(address, options)
+		if errors.Is(sample.Err, ntp.ErrAuthFailed) {
+			sample.Err = fmt.Errorf("%w: response from %s doesn't verify with %s", ErrSymmetricAuthFailed, address, key)
+		} else if sample.Err == nil {
+			sample.Auth = key.String()
+		}
+		return sample
+	}
+}
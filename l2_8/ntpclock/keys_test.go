@@ -0,0 +1,91 @@
+package ntpclock
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+const testKeyFile = `# ntpd-style key file
+1 M     Md5Secret
+2 SHA1  0123456789abcdef0123456789abcdef01234567  # hex, longer than 20 chars
+3 AES128CMAC 000102030405060708090a0b0c0d0e0f
+4 sha256 sha256-secret
+`
+
+func TestParseKeyFile(t *testing.T) {
+	keys, err := ParseKeyFile(strings.NewReader(testKeyFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 4 {
+		t.Fatalf("expected 4 keys, got %d", len(keys))
+	}
+	if keys[1].Type != ntp.AuthMD5 || string(keys[1].Key) != "Md5Secret" {
+		t.Errorf("unexpected key 1: %+v", keys[1])
+	}
+	if keys[2].Type != ntp.AuthSHA1 || len(keys[2].Key) != 20 {
+		t.Errorf("unexpected key 2: %+v", keys[2])
+	}
+	if keys[3].Type != ntp.AuthAES128 || len(keys[3].Key) != 16 {
+		t.Errorf("unexpected key 3: %+v", keys[3])
+	}
+	if keys[4].Type != ntp.AuthSHA256 {
+		t.Errorf("unexpected key 4: %+v", keys[4])
+	}
+
+	invalid := []string{
+		"1 MD5",
+		"0 MD5 secret",
+		"70000 MD5 secret",
+		"1 RC4 secret",
+		"1 AES128CMAC short",
+		"1 SHA1 zzzzzzzzzzzzzzzzzzzzzzzzzzzz",
+	}
+	for _, line := range invalid {
+		if _, err = ParseKeyFile(strings.NewReader(line)); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestSymmetricQuery(t *testing.T) {
+	keys, err := ParseKeyFile(strings.NewReader(testKeyFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	address := startFakeNTP(t, fakeResponder{})
+	server := NewServer(fakeResponder{stratum: 2})
+	server.Keys = keys
+	keyedAddress := serveOnLoopback(t, server)
+
+	options := ntp.QueryOptions{Timeout: time.Second}
+	for id := uint16(1); id <= 4; id++ {
+		sample := SymmetricQuery(options, keys[id])(keyedAddress)
+		if sample.Err != nil {
+			t.Errorf("key %d: %v", id, sample.Err)
+			continue
+		}
+		if sample.Auth != keys[id].String() {
+			t.Errorf("key %d: unexpected auth %q", id, sample.Auth)
+		}
+	}
+
+	// same key id, different secret: the server answers with a crypto-NAK
+	wrongKey := keys[1]
+	wrongKey.Key = []byte("WrongSecret")
+	sample := SymmetricQuery(options, wrongKey)(keyedAddress)
+	if !errors.Is(sample.Err, ErrSymmetricAuthFailed) {
+		t.Errorf("expected %v, got %v", ErrSymmetricAuthFailed, sample.Err)
+	}
+
+	// a server without keys answers unsigned
+	sample = SymmetricQuery(options, keys[2])(address)
+	if !errors.Is(sample.Err, ErrSymmetricAuthFailed) {
+		t.Errorf("expected %v for unsigned response, got %v", ErrSymmetricAuthFailed, sample.Err)
+	}
+}
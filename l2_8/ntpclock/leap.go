@@ -0,0 +1,174 @@
+package ntpclock
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// LeapStyle is how a server passes a leap second
+type LeapStyle int
+
+// smearDivergence is how far a smearing server drifts from stepping ones before it's noticed:
+// Google's 24h smear gets there in about half an hour, while healthy servers agree within milliseconds
+const smearDivergence = 20 * time.Millisecond
+
+const (
+	// LeapStyleUnknown is for samples without a valid response or without evidence either way
+	LeapStyleUnknown LeapStyle = iota
+	// LeapStyleStep means the server announces leap seconds and steps its clock (standard NTP)
+	LeapStyleStep
+	// LeapStyleSmear means the server spreads the leap second over many hours and never announces it
+	LeapStyleSmear
+)
+
+// String returns the style name used in output
+func (s LeapStyle) String() string {
+	switch s {
+	case LeapStyleStep:
+		return "step"
+	case LeapStyleSmear:
+		return "smear"
+	default:
+		return "unknown"
+	}
+}
+
+// smearingReferenceIDs are reference ids that only smearing providers use
+var smearingReferenceIDs = map[string]struct{}{
+	"GOOG": {},
+}
+
+// smearingHosts are names and addresses of well-known public smearing services
+var smearingHosts = []string{
+	"time.google.com", "time1.google.com", "time2.google.com", "time3.google.com", "time4.google.com",
+	"time.aws.com", "169.254.169.123", "fd00:ec2::123",
+	"time.facebook.com", "time1.facebook.com", "time2.facebook.com", "time3.facebook.com",
+	"time4.facebook.com", "time5.facebook.com",
+}
+
+// ClassifyLeap tells how the server of a sample handles leap seconds from this sample alone
+//
+// a server that announces a leap second steps (smearing servers hide the leap indicator),
+// a server with a smearing provider's reference id or host name smears.
+// Anything else is unknown: a plain NTP server looks the same as a smearing one until a leap is near,
+// AnalyzeLeap compares it with the other servers then
+func ClassifyLeap(sample Sample) LeapStyle {
+	if sample.Response == nil || sample.Err != nil {
+		return LeapStyleUnknown
+	}
+
+	response := sample.Response
+	if response.Leap == ntp.LeapAddSecond || response.Leap == ntp.LeapDelSecond {
+		return LeapStyleStep
+	}
+	if response.Stratum == 1 {
+		if _, ok := smearingReferenceIDs[referenceString(response.ReferenceID)]; ok {
+			return LeapStyleSmear
+		}
+	}
+
+	host, _ := splitHostPort(sample.Address)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, smearing := range smearingHosts {
+		if host == smearing {
+			return LeapStyleSmear
+		}
+	}
+	return LeapStyleUnknown
+}
+
+// LeapStatus is what a set of samples tells about leap seconds
+type LeapStatus struct {
+	// Pending is LeapAddSecond or LeapDelSecond if any server announces a leap, LeapNoWarning otherwise
+	Pending ntp.LeapIndicator
+	// At is the moment the leap takes effect: the start of the next UTC month, zero if nothing is pending
+	At time.Time
+	// Announcing are indices of samples that announce the pending leap
+	Announcing []int
+	// Styles is the leap style of every sample, LeapStyleUnknown for invalid ones and those without evidence
+	Styles []LeapStyle
+	// Smearing and Stepping are indices of valid samples positively classified either way
+	Smearing []int
+	Stepping []int
+	// Mixed is true when the samples have both smearing and stepping servers:
+	// around a leap second their time differs by up to a second
+	Mixed bool
+}
+
+// AnalyzeLeap collects leap indicators of valid samples and detects a mix of smearing and stepping servers
+//
+// servers ClassifyLeap can't tell are compared with the announcing ones while a leap is pending:
+// a server that is silent about the leap on its last day, or whose offset diverges from the announcing
+// servers the way a smear does, smears. now is used to find the end of the month when a leap is pending
+func AnalyzeLeap(samples []Sample, now time.Time) LeapStatus {
+	status := LeapStatus{Styles: make([]LeapStyle, len(samples))}
+	for i, sample := range samples {
+		if sample.Response == nil || sample.Err != nil {
+			continue
+		}
+		status.Styles[i] = ClassifyLeap(sample)
+
+		leap := sample.Response.Leap
+		if leap == ntp.LeapAddSecond || leap == ntp.LeapDelSecond {
+			status.Announcing = append(status.Announcing, i)
+			status.Pending = leap
+		}
+	}
+
+	if status.Pending != ntp.LeapNoWarning {
+		// leap seconds happen at the end of a UTC month (RFC 5905: the last second of the current month)
+		utc := now.UTC()
+		status.At = time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		confirmSmearing(samples, &status, now)
+	}
+
+	for i, style := range status.Styles {
+		switch style {
+		case LeapStyleSmear:
+			status.Smearing = append(status.Smearing, i)
+		case LeapStyleStep:
+			status.Stepping = append(status.Stepping, i)
+		}
+	}
+	status.Mixed = len(status.Smearing) > 0 && len(status.Stepping) > 0
+	return status
+}
+
+// confirmSmearing classifies the unknown servers of a pending leap by comparing them with the announcing ones
+//
+// on the last day before the leap every stepping server announces it (the leap indicator is about the end
+// of the current day), so a silent one smears. Before that only a smear-like divergence counts: inserting
+// a second slows a smearing clock down and puts it behind the steppers, deleting one puts it ahead
+func confirmSmearing(samples []Sample, status *LeapStatus, now time.Time) {
+	lastDay := !now.Before(status.At.AddDate(0, 0, -1))
+
+	offsets := make([]time.Duration, 0, len(status.Announcing))
+	for _, i := range status.Announcing {
+		offsets = append(offsets, samples[i].Response.ClockOffset)
+	}
+	slices.Sort(offsets)
+	announced := offsets[len(offsets)/2]
+
+	for i, style := range status.Styles {
+		if style != LeapStyleUnknown || samples[i].Response == nil || samples[i].Err != nil {
+			continue
+		}
+
+		divergence := samples[i].Response.ClockOffset - announced
+		if status.Pending == ntp.LeapDelSecond {
+			divergence = -divergence
+		}
+		if lastDay || divergence < -smearDivergence {
+			status.Styles[i] = LeapStyleSmear
+		}
+	}
+}
+
+// referenceString returns the reference id as ASCII, stratum 1 servers name their source this way
+func referenceString(referenceID uint32) string {
+	b := []byte{byte(referenceID >> 24), byte(referenceID >> 16), byte(referenceID >> 8), byte(referenceID)}
+	return strings.TrimRight(string(b), "\x00")
+}
@@ -0,0 +1,105 @@
+package ntpclock
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestClassifyLeap(t *testing.T) {
+	googleReference, _ := ParseReferenceID("GOOG")
+	cases := []struct {
+		name     string
+		sample   Sample
+		expected LeapStyle
+	}{
+		{"announces leap", Sample{Address: "10.0.0.1:123", Response: &ntp.Response{Stratum: 2, Leap: ntp.LeapAddSecond}}, LeapStyleStep},
+		{"google refid", Sample{Address: "10.0.0.2:123", Response: &ntp.Response{Stratum: 1, ReferenceID: googleReference}}, LeapStyleSmear},
+		{"google host", Sample{Address: "time.google.com", Response: &ntp.Response{Stratum: 2}}, LeapStyleSmear},
+		{"aws host with port", Sample{Address: "169.254.169.123:123", Response: &ntp.Response{Stratum: 3}}, LeapStyleSmear},
+		{"plain ntp", Sample{Address: "pool.ntp.org", Response: &ntp.Response{Stratum: 2}}, LeapStyleUnknown},
+		{"unlisted address", Sample{Address: "192.0.2.1:123", Response: &ntp.Response{Stratum: 2}}, LeapStyleUnknown},
+		{"no response", Sample{Address: "time.google.com", Err: ntp.ErrInvalidStratum}, LeapStyleUnknown},
+	}
+	for _, c := range cases {
+		if result := ClassifyLeap(c.sample); result != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestAnalyzeLeapMixedSources(t *testing.T) {
+	// a stepping server announces the leap, google hides it behind the smear
+	stepping := startFakeNTP(t, fakeResponder{leap: ntp.LeapAddSecond})
+	smearing := startFakeNTP(t, fakeResponder{stratum: 1})
+	plain := startFakeNTP(t, fakeResponder{})
+
+	samples := QueryAll([]string{stepping, smearing, plain}, PlainQuery(ntp.QueryOptions{Timeout: time.Second}))
+	// the loopback stand-in can't have google's name, so pretend it has
+	samples[1].Address = "time.google.com"
+
+	now := time.Date(2026, time.December, 31, 12, 0, 0, 0, time.FixedZone("UTC+3", 3*3600))
+	status := AnalyzeLeap(samples, now)
+
+	if status.Pending != ntp.LeapAddSecond || !slices.Equal(status.Announcing, []int{0}) {
+		t.Errorf("expected insertion announced by the first server: %+v", status)
+	}
+	if expected := time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC); !status.At.Equal(expected) {
+		t.Errorf("expected leap at %v, got %v", expected, status.At)
+	}
+	// on the last day the plain server is silent about the leap, so it smears too
+	if !status.Mixed || !slices.Equal(status.Smearing, []int{1, 2}) || !slices.Equal(status.Stepping, []int{0}) {
+		t.Errorf("expected mixed smearing and stepping sources: %+v", status)
+	}
+}
+
+func TestAnalyzeLeapNothingPending(t *testing.T) {
+	samples := []Sample{
+		{Address: "10.0.0.1:123", Response: &ntp.Response{Stratum: 2}},
+		{Address: "10.0.0.2:123", Response: &ntp.Response{Stratum: 0, Leap: ntp.LeapAddSecond}, Err: ntp.ErrKissOfDeath},
+	}
+	status := AnalyzeLeap(samples, time.Now())
+	if status.Pending != ntp.LeapNoWarning || !status.At.IsZero() || status.Mixed {
+		t.Errorf("invalid responses must be ignored: %+v", status)
+	}
+}
+
+func TestAnalyzeLeapUnlistedServers(t *testing.T) {
+	// without a pending leap nothing tells how an unlisted server passes it
+	samples := []Sample{
+		{Address: "time.google.com", Response: &ntp.Response{Stratum: 1}},
+		{Address: "192.0.2.1:123", Response: &ntp.Response{Stratum: 2}},
+	}
+	status := AnalyzeLeap(samples, time.Now())
+	if status.Mixed || !slices.Equal(status.Styles, []LeapStyle{LeapStyleSmear, LeapStyleUnknown}) || status.Stepping != nil {
+		t.Errorf("an unlisted server must stay unknown: %+v", status)
+	}
+}
+
+func TestAnalyzeLeapDivergence(t *testing.T) {
+	// mid-month a silent server may still be a stepping one, only a smear-like divergence gives it away
+	now := time.Date(2026, time.June, 15, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		leap     ntp.LeapIndicator
+		offset   time.Duration
+		expected LeapStyle
+	}{
+		{ntp.LeapAddSecond, -300 * time.Millisecond, LeapStyleSmear},
+		{ntp.LeapAddSecond, 300 * time.Millisecond, LeapStyleUnknown},
+		{ntp.LeapAddSecond, -time.Millisecond, LeapStyleUnknown},
+		{ntp.LeapDelSecond, 300 * time.Millisecond, LeapStyleSmear},
+		{ntp.LeapDelSecond, -300 * time.Millisecond, LeapStyleUnknown},
+	}
+	for _, c := range cases {
+		samples := []Sample{
+			{Address: "192.0.2.1:123", Response: &ntp.Response{Stratum: 2, Leap: c.leap}},
+			{Address: "192.0.2.2:123", Response: &ntp.Response{Stratum: 2, ClockOffset: c.offset}},
+		}
+		status := AnalyzeLeap(samples, now)
+		if status.Styles[1] != c.expected || status.Mixed != (c.expected == LeapStyleSmear) {
+			t.Errorf("leap %v, offset %v: expected %s, got %+v", c.leap, c.offset, c.expected, status)
+		}
+	}
+}
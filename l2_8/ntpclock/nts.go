@@ -0,0 +1,323 @@
+package ntpclock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/beevik/ntp"
+)
+
+// NTS extension field types (RFC 8915, section 5)
+const (
+	efUniqueIdentifier  uint16 = 0x0104
+	efNTSCookie         uint16 = 0x0204
+	efCookiePlaceholder uint16 = 0x0304
+	efNTSAuthenticator  uint16 = 0x0404
+)
+
+const (
+	// ntsWantedCookies is how many cookies we try to keep, as recommended by RFC 8915
+	ntsWantedCookies = 8
+	// ntsUniqueIDSize is the size of the random unique identifier of a query
+	ntsUniqueIDSize = 32
+	// AuthNTS is the value of Sample.Auth for NTS-authenticated responses
+	AuthNTS = "nts"
+)
+
+var (
+	// ErrNTSUnauthenticated is returned in required mode when a response has no NTS authenticator
+	ErrNTSUnauthenticated = errors.New("response is not authenticated by NTS")
+	// ErrNTSAuthFailed is returned when a response has an authenticator that doesn't verify
+	ErrNTSAuthFailed = errors.New("nts authentication failed")
+	// ErrNTSNak is returned when the server answers with NTSN kiss code: it can't use our cookie
+	ErrNTSNak = errors.New("server rejected nts cookie (NTSN)")
+	// ErrNTSNoCookies is returned when the session ran out of cookies
+	ErrNTSNoCookies = errors.New("no nts cookies left")
+)
+
+// extensionField is one NTP extension field, offset is its position in the packet
+type extensionField struct {
+	fieldType uint16
+	body      []byte
+	offset    int
+}
+
+// appendExtensionField appends a field padded to a multiple of 4 bytes (and at least 16 bytes, RFC 7822)
+func appendExtensionField(buf []byte, fieldType uint16, body []byte) []byte {
+	length := 4 + len(body)
+	length += (4 - length%4) % 4
+	if length < 16 {
+		length = 16
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, fieldType)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	buf = append(buf, body...)
+	return append(buf, make([]byte, length-4-len(body))...)
+}
+
+// parseExtensionFields parses fields from packet starting at start until the end of the packet
+func parseExtensionFields(packet []byte, start int) ([]extensionField, error) {
+	var fields []extensionField
+	for offset := start; offset < len(packet); {
+		if len(packet)-offset < 4 {
+			return nil, fmt.Errorf("truncated extension field at %d", offset)
+		}
+		length := int(binary.BigEndian.Uint16(packet[offset+2:]))
+		if length < 4 || length%4 != 0 || offset+length > len(packet) {
+			return nil, fmt.Errorf("invalid extension field length %d at %d", length, offset)
+		}
+		fields = append(fields, extensionField{
+			fieldType: binary.BigEndian.Uint16(packet[offset:]),
+			body:      packet[offset+4 : offset+length],
+			offset:    offset,
+		})
+		offset += length
+	}
+	return fields, nil
+}
+
+// findExtensionField returns the first field of the given type
+func findExtensionField(fields []extensionField, fieldType uint16) (extensionField, bool) {
+	for _, field := range fields {
+		if field.fieldType == fieldType {
+			return field, true
+		}
+	}
+	return extensionField{}, false
+}
+
+// appendAuthenticator appends the NTS Authenticator and Encrypted Extension Fields field
+//
+// everything already in buf is the associated data, plaintext holds the encrypted extension fields
+func appendAuthenticator(buf []byte, key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newAESSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, buf)
+
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, (4-len(nonce)%4)%4)...)
+	body = append(body, ciphertext...)
+	return appendExtensionField(buf, efNTSAuthenticator, body), nil
+}
+
+// openAuthenticator verifies the authenticator field of packet and returns the decrypted extension fields
+func openAuthenticator(packet []byte, field extensionField, key []byte) ([]byte, error) {
+	body := field.body
+	if len(body) < 4 {
+		return nil, ErrNTSAuthFailed
+	}
+	nonceLength := int(binary.BigEndian.Uint16(body))
+	ciphertextLength := int(binary.BigEndian.Uint16(body[2:]))
+	paddedNonceLength := nonceLength + (4-nonceLength%4)%4
+	if 4+paddedNonceLength+ciphertextLength > len(body) {
+		return nil, ErrNTSAuthFailed
+	}
+	nonce := body[4 : 4+nonceLength]
+	ciphertext := body[4+paddedNonceLength : 4+paddedNonceLength+ciphertextLength]
+
+	aead, err := newAESSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, packet[:field.offset])
+	if err != nil {
+		return nil, ErrNTSAuthFailed
+	}
+	return plaintext, nil
+}
+
+// ntsExtension authenticates one NTP query with a session cookie, it plugs into ntp.QueryOptions.Extensions
+type ntsExtension struct {
+	session  *ntsSession
+	required bool
+
+	uniqueID      []byte
+	authenticated bool
+}
+
+// newNTSExtension creates an extension for a single query
+func newNTSExtension(session *ntsSession, required bool) *ntsExtension {
+	return &ntsExtension{session: session, required: required}
+}
+
+// ProcessQuery appends unique identifier, cookie, placeholders and the authenticator
+func (e *ntsExtension) ProcessQuery(buf *bytes.Buffer) error {
+	cookie, left, ok := e.session.takeCookie()
+	if !ok {
+		return ErrNTSNoCookies
+	}
+
+	e.uniqueID = make([]byte, ntsUniqueIDSize)
+	if _, err := rand.Read(e.uniqueID); err != nil {
+		return err
+	}
+
+	packet := appendExtensionField(buf.Bytes(), efUniqueIdentifier, e.uniqueID)
+	packet = appendExtensionField(packet, efNTSCookie, cookie)
+
+	// every placeholder asks the server for one more cookie, so the pool refills after lost packets
+	for i := left + 1; i < ntsWantedCookies; i++ {
+		packet = appendExtensionField(packet, efCookiePlaceholder, make([]byte, len(cookie)))
+	}
+
+	packet, err := appendAuthenticator(packet, e.session.c2sKey, nil)
+	if err != nil {
+		return err
+	}
+
+	buf.Reset()
+	buf.Write(packet)
+	return nil
+}
+
+// ProcessResponse checks the unique identifier and the authenticator and stores new cookies
+func (e *ntsExtension) ProcessResponse(buf []byte) error {
+	header, err := parseNtpPacket(buf)
+	if err != nil {
+		return err
+	}
+	if header.Stratum == 0 && header.ReferenceID == kissCodeNTSN {
+		return ErrNTSNak
+	}
+
+	fields, err := parseExtensionFields(buf, ntpHeaderSize)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNTSAuthFailed, err)
+	}
+
+	uniqueID, hasUniqueID := findExtensionField(fields, efUniqueIdentifier)
+	authenticator, hasAuthenticator := findExtensionField(fields, efNTSAuthenticator)
+	if !hasAuthenticator {
+		if e.required {
+			return ErrNTSUnauthenticated
+		}
+		return nil
+	}
+	if !hasUniqueID || !bytes.Equal(uniqueID.body[:min(len(uniqueID.body), ntsUniqueIDSize)], e.uniqueID) {
+		return fmt.Errorf("%w: unique identifier mismatch", ErrNTSAuthFailed)
+	}
+
+	plaintext, err := openAuthenticator(buf, authenticator, e.session.s2cKey)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := parseExtensionFields(plaintext, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNTSAuthFailed, err)
+	}
+	var cookies [][]byte
+	for _, field := range encrypted {
+		if field.fieldType == efNTSCookie {
+			cookies = append(cookies, append([]byte(nil), field.body...))
+		}
+	}
+	e.session.addCookies(cookies)
+
+	e.authenticated = true
+	return nil
+}
+
+// kissCodeNTSN is the NTS negative-acknowledgment kiss code
+const kissCodeNTSN = 'N'<<24 | 'T'<<16 | 'S'<<8 | 'N'
+
+// NTSQuerier queries servers over NTS, keeping one NTS-KE session per address
+//
+// addresses are NTS-KE servers, the NTP server is what the key exchange tells us to use.
+// if required is false, a failed key exchange falls back to plain NTP on the same host
+// and responses without an authenticator are accepted as unauthenticated
+type NTSQuerier struct {
+	options   ntp.QueryOptions
+	tlsConfig *tls.Config
+	required  bool
+
+	mu       sync.Mutex
+	sessions map[string]*ntsSession
+}
+
+// NewNTSQuerier creates a querier, tlsConfig may be nil to verify servers by system roots
+func NewNTSQuerier(options ntp.QueryOptions, tlsConfig *tls.Config, required bool) *NTSQuerier {
+	return &NTSQuerier{
+		options:   options,
+		tlsConfig: tlsConfig,
+		required:  required,
+		sessions:  make(map[string]*ntsSession),
+	}
+}
+
+// Query implements QueryFunc
+func (q *NTSQuerier) Query(address string) Sample {
+	session, err := q.session(address)
+	if err != nil {
+		if q.required {
+			return Sample{Address: address, Err: err}
+		}
+		host, _, splitErr := net.SplitHostPort(address)
+		if splitErr != nil {
+			host = address
+		}
+		sample := queryOne(host, q.options)
+		sample.Address = address
+		return sample
+	}
+
+	extension := newNTSExtension(session, q.required)
+	options := q.options
+	options.Extensions = append(append([]ntp.Extension(nil), q.options.Extensions...), extension)
+
+	sample := queryOne(session.ntpAddress, options)
+	sample.Address = address
+	if errors.Is(sample.Err, ErrNTSNak) {
+		q.forget(address)
+	}
+	if extension.authenticated {
+		sample.Auth = AuthNTS
+	}
+	return sample
+}
+
+// session returns a session with at least one cookie, running a new key exchange if needed
+func (q *NTSQuerier) session(address string) (*ntsSession, error) {
+	q.mu.Lock()
+	session, ok := q.sessions[address]
+	q.mu.Unlock()
+	if ok && session.cookieCount() > 0 {
+		return session, nil
+	}
+
+	timeout := q.options.Timeout
+	if timeout == 0 {
+		timeout = defaultQueryTimeout
+	}
+	session, err := ntsKeyExchange(address, q.tlsConfig, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	q.sessions[address] = session
+	q.mu.Unlock()
+	return session, nil
+}
+
+// forget drops the session of address, the next query will run the key exchange again
+func (q *NTSQuerier) forget(address string) {
+	q.mu.Lock()
+	delete(q.sessions, address)
+	q.mu.Unlock()
+}
@@ -0,0 +1,316 @@
+package ntpclock
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestAESSIVVector(t *testing.T) {
+	// RFC 5297, appendix A.1
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ad, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	expected, _ := hex.DecodeString("85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+
+	aead, err := newAESSIV(key)
+	if err != nil {
+		t.Fatalf("newAESSIV: %v", err)
+	}
+	ciphertext := aead.Seal(nil, nil, plaintext, ad)
+	if !bytes.Equal(ciphertext, expected) {
+		t.Fatalf("expected %x, got %x", expected, ciphertext)
+	}
+
+	opened, err := aead.Open(nil, nil, ciphertext, ad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("open: %v, %x", err, opened)
+	}
+
+	ciphertext[20] ^= 1
+	if _, err = aead.Open(nil, nil, ciphertext, ad); !errors.Is(err, ErrSIVAuthFailed) {
+		t.Fatalf("tampered ciphertext must not open, got %v", err)
+	}
+}
+
+// ntsStandIn is a local NTS-KE server plus an NTP server that understands its cookies
+type ntsStandIn struct {
+	keAddress string
+	// clientTLS trusts the self-signed certificate of the stand-in
+	clientTLS *tls.Config
+	clock     fakeResponder
+
+	// stripAuth makes the NTP server answer without NTS fields, like an attacker would
+	stripAuth bool
+	// nak makes the NTP server reject every cookie with the NTSN kiss code
+	nak bool
+
+	mu   sync.Mutex
+	keys map[string][2][]byte
+}
+
+// startNTSStandIn starts both servers on loopback until the test ends
+func startNTSStandIn(t *testing.T, clock fakeResponder) *ntsStandIn {
+	t.Helper()
+
+	certificate, pool := selfSignedCertificate(t)
+	s := &ntsStandIn{
+		clientTLS: &tls.Config{RootCAs: pool},
+		clock:     clock,
+		keys:      make(map[string][2][]byte),
+	}
+	if s.clock.stratum == 0 {
+		s.clock.stratum = 2
+	}
+
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	t.Cleanup(func() { _ = udpConn.Close() })
+	go s.serveNTP(udpConn)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{ntsKEALPN},
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatalf("listen tls: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	ntpPort := uint16(udpConn.LocalAddr().(*net.UDPAddr).Port)
+	go s.serveKE(listener, ntpPort)
+
+	s.keAddress = listener.Addr().String()
+	return s
+}
+
+// serveKE answers key exchange requests with 8 cookies and the address of the NTP server
+func (s *ntsStandIn) serveKE(listener net.Listener, ntpPort uint16) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			tlsConn := conn.(*tls.Conn)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			for {
+				record, err := readNTSRecord(tlsConn)
+				if err != nil {
+					return
+				}
+				if record.recordType == ntsRecordEndOfMessage {
+					break
+				}
+			}
+
+			state := tlsConn.ConnectionState()
+			c2s, _ := exportNTSKey(&state, 0)
+			s2c, _ := exportNTSKey(&state, 1)
+
+			var response []byte
+			response = appendNTSRecord(response, ntsRecord{true, ntsRecordNextProtocol, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4)})
+			response = appendNTSRecord(response, ntsRecord{false, ntsRecordAEADAlgorithm, binary.BigEndian.AppendUint16(nil, ntsAEADAESSIVCMAC)})
+			for i := 0; i < ntsWantedCookies; i++ {
+				response = appendNTSRecord(response, ntsRecord{false, ntsRecordNewCookie, s.newCookie(c2s, s2c)})
+			}
+			response = appendNTSRecord(response, ntsRecord{false, ntsRecordServerNegotiation, []byte("127.0.0.1")})
+			response = appendNTSRecord(response, ntsRecord{false, ntsRecordPortNegotiation, binary.BigEndian.AppendUint16(nil, ntpPort)})
+			response = appendNTSRecord(response, ntsRecord{true, ntsRecordEndOfMessage, nil})
+			_, _ = tlsConn.Write(response)
+		}()
+	}
+}
+
+// newCookie remembers keys under a random cookie, a real server would encrypt keys into the cookie instead
+func (s *ntsStandIn) newCookie(c2s, s2c []byte) []byte {
+	cookie := make([]byte, 64)
+	_, _ = rand.Read(cookie)
+	s.mu.Lock()
+	s.keys[string(cookie)] = [2][]byte{c2s, s2c}
+	s.mu.Unlock()
+	return cookie
+}
+
+// serveNTP answers authenticated requests, requests with unknown cookies or bad authenticators are dropped
+func (s *ntsStandIn) serveNTP(conn net.PacketConn) {
+	server := NewServer(s.clock)
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request := buf[:n]
+		received, _ := s.clock.ServerTime()
+
+		fields, err := parseExtensionFields(request, ntpHeaderSize)
+		if err != nil {
+			continue
+		}
+		uniqueID, _ := findExtensionField(fields, efUniqueIdentifier)
+		cookie, _ := findExtensionField(fields, efNTSCookie)
+		authenticator, ok := findExtensionField(fields, efNTSAuthenticator)
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		keys, known := s.keys[string(cookie.body)]
+		s.mu.Unlock()
+		if !known {
+			continue
+		}
+		if _, err = openAuthenticator(request, authenticator, keys[0]); err != nil {
+			continue
+		}
+
+		response, err := server.respond(request, received)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case s.nak:
+			packet, _ := parseNtpPacket(response)
+			packet.Stratum = 0
+			packet.ReferenceID = kissCodeNTSN
+			response = appendExtensionField(packet.marshal(), efUniqueIdentifier, uniqueID.body)
+		case s.stripAuth:
+		default:
+			// one fresh cookie for the used one and one for every placeholder
+			plaintext := appendExtensionField(nil, efNTSCookie, s.newCookie(keys[0], keys[1]))
+			for _, field := range fields {
+				if field.fieldType == efCookiePlaceholder {
+					plaintext = appendExtensionField(plaintext, efNTSCookie, s.newCookie(keys[0], keys[1]))
+				}
+			}
+			response = appendExtensionField(response, efUniqueIdentifier, uniqueID.body)
+			response, _ = appendAuthenticator(response, keys[1], plaintext)
+		}
+
+		_, _ = conn.WriteTo(response, addr)
+	}
+}
+
+// selfSignedCertificate creates a certificate for 127.0.0.1 and a pool that trusts it
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestNTSQuery(t *testing.T) {
+	standIn := startNTSStandIn(t, fakeResponder{offset: 2 * time.Second})
+	querier := NewNTSQuerier(ntp.QueryOptions{Timeout: time.Second}, standIn.clientTLS, true)
+
+	// several queries in a row reuse the session and live on the cookies from responses
+	for i := 0; i < ntsWantedCookies+2; i++ {
+		sample := querier.Query(standIn.keAddress)
+		if sample.Err != nil {
+			t.Fatalf("query %d: %v", i, sample.Err)
+		}
+		if sample.Auth != AuthNTS {
+			t.Fatalf("query %d: expected nts authentication, got %q", i, sample.Auth)
+		}
+		if diff := sample.Response.ClockOffset - 2*time.Second; diff > 50*time.Millisecond || diff < -50*time.Millisecond {
+			t.Fatalf("query %d: expected offset of about 2s, got %v", i, sample.Response.ClockOffset)
+		}
+	}
+
+	session, err := querier.session(standIn.keAddress)
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	if count := session.cookieCount(); count != ntsWantedCookies {
+		t.Errorf("expected the cookie pool to stay full, got %d cookies", count)
+	}
+}
+
+func TestNTSUnauthenticatedResponse(t *testing.T) {
+	standIn := startNTSStandIn(t, fakeResponder{})
+	standIn.stripAuth = true
+
+	required := NewNTSQuerier(ntp.QueryOptions{Timeout: time.Second}, standIn.clientTLS, true)
+	sample := required.Query(standIn.keAddress)
+	if !errors.Is(sample.Err, ErrNTSUnauthenticated) {
+		t.Fatalf("expected %v, got %v", ErrNTSUnauthenticated, sample.Err)
+	}
+
+	optional := NewNTSQuerier(ntp.QueryOptions{Timeout: time.Second}, standIn.clientTLS, false)
+	sample = optional.Query(standIn.keAddress)
+	if sample.Err != nil {
+		t.Fatalf("unauthenticated response must be accepted without -require-auth: %v", sample.Err)
+	}
+	if sample.Auth != "" {
+		t.Errorf("response must not be marked authenticated, got %q", sample.Auth)
+	}
+}
+
+func TestNTSNak(t *testing.T) {
+	standIn := startNTSStandIn(t, fakeResponder{})
+	standIn.nak = true
+
+	querier := NewNTSQuerier(ntp.QueryOptions{Timeout: time.Second}, standIn.clientTLS, true)
+	sample := querier.Query(standIn.keAddress)
+	if !errors.Is(sample.Err, ErrNTSNak) {
+		t.Fatalf("expected %v, got %v", ErrNTSNak, sample.Err)
+	}
+	if _, ok := querier.sessions[standIn.keAddress]; ok {
+		t.Error("session must be dropped after NTSN")
+	}
+}
+
+func TestNTSKeyExchangeFailure(t *testing.T) {
+	// the stand-in certificate is not trusted by system roots
+	standIn := startNTSStandIn(t, fakeResponder{})
+
+	querier := NewNTSQuerier(ntp.QueryOptions{Timeout: time.Second}, nil, true)
+	sample := querier.Query(standIn.keAddress)
+	if !errors.Is(sample.Err, ErrNTSKeyExchange) {
+		t.Fatalf("expected %v, got %v", ErrNTSKeyExchange, sample.Err)
+	}
+}
@@ -0,0 +1,227 @@
+package ntpclock
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NTS-KE constants from RFC 8915
+const (
+	ntsKEDefaultPort = 4460
+	ntsKEALPN        = "ntske/1"
+	ntsKEExporter    = "EXPORTER-network-time-security"
+
+	ntsProtocolNTPv4  uint16 = 0
+	ntsAEADAESSIVCMAC uint16 = 15
+	ntsKeySize               = 32
+	ntsRecordCritical uint16 = 0x8000
+)
+
+// NTS-KE record types
+const (
+	ntsRecordEndOfMessage      uint16 = 0
+	ntsRecordNextProtocol      uint16 = 1
+	ntsRecordError             uint16 = 2
+	ntsRecordWarning           uint16 = 3
+	ntsRecordAEADAlgorithm     uint16 = 4
+	ntsRecordNewCookie         uint16 = 5
+	ntsRecordServerNegotiation uint16 = 6
+	ntsRecordPortNegotiation   uint16 = 7
+)
+
+// ErrNTSKeyExchange is returned when the NTS-KE server can't give us keys and cookies
+var ErrNTSKeyExchange = errors.New("nts key exchange failed")
+
+// ntsSession is the result of NTS-KE: where to send NTP queries and how to authenticate them
+type ntsSession struct {
+	// ntpAddress is host:port of the NTP server, it may differ from the NTS-KE server
+	ntpAddress string
+	c2sKey     []byte
+	s2cKey     []byte
+
+	// mu guards cookies: every query takes one and every authenticated response brings new ones
+	mu      sync.Mutex
+	cookies [][]byte
+}
+
+// takeCookie removes a cookie from the session, the second value is the number of cookies left
+func (s *ntsSession) takeCookie() ([]byte, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cookies) == 0 {
+		return nil, 0, false
+	}
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+	return cookie, len(s.cookies), true
+}
+
+// addCookies stores fresh cookies from a response
+func (s *ntsSession) addCookies(cookies [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cookies = append(s.cookies, cookies...)
+}
+
+// cookieCount returns the number of unused cookies
+func (s *ntsSession) cookieCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cookies)
+}
+
+// ntsRecord is one NTS-KE record, critical is the high bit of the type
+type ntsRecord struct {
+	critical   bool
+	recordType uint16
+	body       []byte
+}
+
+// appendNTSRecord appends a record in wire format
+func appendNTSRecord(buf []byte, record ntsRecord) []byte {
+	recordType := record.recordType
+	if record.critical {
+		recordType |= ntsRecordCritical
+	}
+	buf = binary.BigEndian.AppendUint16(buf, recordType)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(record.body)))
+	return append(buf, record.body...)
+}
+
+// readNTSRecord reads one record from r
+func readNTSRecord(r io.Reader) (ntsRecord, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return ntsRecord{}, err
+	}
+	recordType := binary.BigEndian.Uint16(header[:2])
+	body := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return ntsRecord{}, err
+	}
+	return ntsRecord{
+		critical:   recordType&ntsRecordCritical != 0,
+		recordType: recordType &^ ntsRecordCritical,
+		body:       body,
+	}, nil
+}
+
+// ntsKeyExchange runs NTS-KE with the server at address (host or host:port, default port 4460)
+//
+// tlsConfig may be nil to use system roots, it is copied and adjusted for TLS 1.3 and the ntske/1 ALPN
+func ntsKeyExchange(address string, tlsConfig *tls.Config, timeout time.Duration) (*ntsSession, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, strconv.Itoa(ntsKEDefaultPort)
+	}
+
+	config := &tls.Config{}
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	config.MinVersion = tls.VersionTLS13
+	config.NextProtos = []string{ntsKEALPN}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNTSKeyExchange, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ntsKEALPN {
+		return nil, fmt.Errorf("%w: server didn't negotiate %s", ErrNTSKeyExchange, ntsKEALPN)
+	}
+
+	// request: NTPv4 with AES-SIV-CMAC-256
+	var request []byte
+	request = appendNTSRecord(request, ntsRecord{true, ntsRecordNextProtocol, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4)})
+	request = appendNTSRecord(request, ntsRecord{false, ntsRecordAEADAlgorithm, binary.BigEndian.AppendUint16(nil, ntsAEADAESSIVCMAC)})
+	request = appendNTSRecord(request, ntsRecord{true, ntsRecordEndOfMessage, nil})
+	if _, err = conn.Write(request); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNTSKeyExchange, err)
+	}
+
+	session := &ntsSession{}
+	ntpHost, ntpPort := host, "123"
+	protocolAgreed, aeadAgreed := false, false
+
+	for {
+		record, err := readNTSRecord(conn)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading response: %w", ErrNTSKeyExchange, err)
+		}
+
+		switch record.recordType {
+		case ntsRecordEndOfMessage:
+			if !protocolAgreed || !aeadAgreed {
+				return nil, fmt.Errorf("%w: server didn't agree on NTPv4 with AES-SIV-CMAC-256", ErrNTSKeyExchange)
+			}
+			if len(session.cookies) == 0 {
+				return nil, fmt.Errorf("%w: server sent no cookies", ErrNTSKeyExchange)
+			}
+			session.ntpAddress = net.JoinHostPort(ntpHost, ntpPort)
+
+			session.c2sKey, err = exportNTSKey(&state, 0)
+			if err != nil {
+				return nil, err
+			}
+			session.s2cKey, err = exportNTSKey(&state, 1)
+			if err != nil {
+				return nil, err
+			}
+			return session, nil
+		case ntsRecordNextProtocol:
+			protocolAgreed = bytes.Equal(record.body, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4))
+		case ntsRecordAEADAlgorithm:
+			aeadAgreed = bytes.Equal(record.body, binary.BigEndian.AppendUint16(nil, ntsAEADAESSIVCMAC))
+		case ntsRecordNewCookie:
+			session.cookies = append(session.cookies, record.body)
+		case ntsRecordServerNegotiation:
+			ntpHost = string(record.body)
+		case ntsRecordPortNegotiation:
+			if len(record.body) != 2 {
+				return nil, fmt.Errorf("%w: malformed port record", ErrNTSKeyExchange)
+			}
+			ntpPort = strconv.Itoa(int(binary.BigEndian.Uint16(record.body)))
+		case ntsRecordError:
+			code := -1
+			if len(record.body) == 2 {
+				code = int(binary.BigEndian.Uint16(record.body))
+			}
+			return nil, fmt.Errorf("%w: server error code %d", ErrNTSKeyExchange, code)
+		case ntsRecordWarning:
+			// warnings don't stop the exchange
+		default:
+			if record.critical {
+				return nil, fmt.Errorf("%w: unknown critical record %d", ErrNTSKeyExchange, record.recordType)
+			}
+		}
+	}
+}
+
+// exportNTSKey derives the client-to-server (direction 0) or server-to-client (direction 1) key from the TLS session
+func exportNTSKey(state *tls.ConnectionState, direction byte) ([]byte, error) {
+	exporterContext := binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4)
+	exporterContext = binary.BigEndian.AppendUint16(exporterContext, ntsAEADAESSIVCMAC)
+	exporterContext = append(exporterContext, direction)
+
+	key, err := state.ExportKeyingMaterial(ntsKEExporter, exporterContext, ntsKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: exporting keys: %w", ErrNTSKeyExchange, err)
+	}
+	return key, nil
+}
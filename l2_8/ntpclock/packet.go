@@ -0,0 +1,131 @@
+package ntpclock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 (NTP era 0) and 1970-01-01
+const ntpEpochOffset = 2208988800
+
+// ntpHeaderSize is the size of the fixed part of an NTP packet
+const ntpHeaderSize = 48
+
+// NTP association modes used by the tool
+const (
+	modeClient uint8 = 3
+	modeServer uint8 = 4
+)
+
+// ErrShortPacket is returned when a datagram is smaller than the NTP header
+var ErrShortPacket = errors.New("packet is shorter than NTP header")
+
+// ntpPacket is the fixed 48-byte NTP header, field order matches the wire format (RFC 5905, figure 8)
+type ntpPacket struct {
+	LiVnMode       uint8 // Leap Indicator (2) + Version (3) + Mode (3)
+	Stratum        uint8
+	Poll           int8
+	Precision      int8
+	RootDelay      uint32 // NTP short format, Q16.16 seconds
+	RootDispersion uint32 // NTP short format, Q16.16 seconds
+	ReferenceID    uint32
+	ReferenceTime  uint64 // NTP timestamp format, Q32.32 seconds since 1900
+	OriginTime     uint64
+	ReceiveTime    uint64
+	TransmitTime   uint64
+}
+
+// leap returns the leap indicator bits
+func (p *ntpPacket) leap() uint8 {
+	return p.LiVnMode >> 6
+}
+
+// version returns the version number bits
+func (p *ntpPacket) version() uint8 {
+	return (p.LiVnMode >> 3) & 0x07
+}
+
+// mode returns the association mode bits
+func (p *ntpPacket) mode() uint8 {
+	return p.LiVnMode & 0x07
+}
+
+// setLiVnMode sets all 3 bit fields at once
+func (p *ntpPacket) setLiVnMode(leap, version, mode uint8) {
+	p.LiVnMode = leap<<6 | (version&0x07)<<3 | mode&0x07
+}
+
+// marshal returns the wire representation of the header
+func (p *ntpPacket) marshal() []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, p)
+	return buf.Bytes()
+}
+
+// parseNtpPacket reads the fixed header from the beginning of a datagram, extensions are ignored
+func parseNtpPacket(data []byte) (*ntpPacket, error) {
+	if len(data) < ntpHeaderSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrShortPacket, len(data))
+	}
+	p := &ntpPacket{}
+	err := binary.Read(bytes.NewReader(data[:ntpHeaderSize]), binary.BigEndian, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// toNtpTimestamp converts time to a 64-bit NTP timestamp
+func toNtpTimestamp(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// fromNtpTimestamp converts a 64-bit NTP timestamp of era 0 to time
+func fromNtpTimestamp(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanoseconds := ((ts & 0xffffffff) * uint64(time.Second)) >> 32
+	return time.Unix(seconds, int64(nanoseconds))
+}
+
+// toNtpShort converts a non-negative duration to NTP short format, saturating on overflow
+func toNtpShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	if d >= 1<<16*time.Second {
+		return 0xffffffff
+	}
+	return uint32(d * (1 << 16) / time.Second)
+}
+
+// ParseReferenceID converts a reference id from the command line to its 32-bit form
+//
+// an IPv4 address is used as is (upstream of a stratum 2+ server),
+// anything else must be 1 to 4 ASCII characters (reference clock code like GPS, LOCL)
+func ParseReferenceID(s string) (uint32, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return 0, fmt.Errorf("reference id %q: only IPv4 address can be used", s)
+		}
+		return binary.BigEndian.Uint32(ip4), nil
+	}
+
+	if len(s) == 0 || len(s) > 4 {
+		return 0, fmt.Errorf("reference id %q must be 1-4 ASCII characters or an IPv4 address", s)
+	}
+	var b [4]byte
+	for i := 0; i < len(s); i++ {
+		if s[i] < 32 || s[i] > 126 {
+			return 0, fmt.Errorf("reference id %q must be printable ASCII", s)
+		}
+		b[i] = s[i]
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
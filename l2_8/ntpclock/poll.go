@@ -0,0 +1,133 @@
+package ntpclock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// poll interval limits from RFC 5905: MINPOLL = 2^4 s and MAXPOLL = 2^17 s (about 36 hours)
+const (
+	MinPoll = 16 * time.Second
+	MaxPoll = 1 << 17 * time.Second
+)
+
+// kiss codes that change how we poll a server (RFC 5905, 7.4)
+const (
+	kissRate = "RATE"
+	kissDeny = "DENY"
+	kissRstr = "RSTR"
+)
+
+// ErrBackoff is returned instead of querying a server that asked us to slow down
+var ErrBackoff = errors.New("backing off")
+
+// ErrDenied is returned instead of querying a server that denied us access
+var ErrDenied = errors.New("server denied access, not querying it anymore")
+
+// ClampPoll keeps a poll interval within RFC 5905 limits
+func ClampPoll(interval time.Duration) time.Duration {
+	return min(max(interval, MinPoll), MaxPoll)
+}
+
+// pollState is what a Poller remembers about one server
+type pollState struct {
+	interval time.Duration
+	next     time.Time
+	denied   string
+}
+
+// Poller keeps every server polled at a rate it accepts
+//
+// RATE kiss doubles the poll interval of the server (and makes it at least the poll the server announced),
+// and the server isn't queried until it passes. DENY and RSTR stop querying the server for good.
+// Every normal response halves the interval back towards the base one and lifts the wait
+type Poller struct {
+	base time.Duration
+
+	// now is the clock of the poller, replaced in tests
+	now func() time.Time
+
+	mu      sync.Mutex
+	servers map[string]*pollState
+}
+
+// NewPoller creates a poller for servers polled every base interval
+func NewPoller(base time.Duration) *Poller {
+	return &Poller{base: base, now: time.Now, servers: make(map[string]*pollState)}
+}
+
+// Wrap returns a QueryFunc that honors kisses of death received through query
+func (p *Poller) Wrap(query QueryFunc) QueryFunc {
+	return func(address string) Sample {
+		if err := p.allow(address); err != nil {
+			return Sample{Address: address, Err: err}
+		}
+		sample := query(address)
+		p.update(address, sample)
+		return sample
+	}
+}
+
+// Interval returns the current poll interval of address
+func (p *Poller) Interval(address string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state(address).interval
+}
+
+// allow returns nil if address may be queried now
+func (p *Poller) allow(address string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state(address)
+	if state.denied != "" {
+		return fmt.Errorf("%w (%s)", ErrDenied, state.denied)
+	}
+	if now := p.now(); now.Before(state.next) {
+		return fmt.Errorf("%w: %s asked to slow down, next poll in %v", ErrBackoff, address, state.next.Sub(now).Round(time.Second))
+	}
+	return nil
+}
+
+// update adjusts the poll interval of address after a query
+func (p *Poller) update(address string, sample Sample) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state(address)
+	response := sample.Response
+	switch {
+	case response == nil:
+		// no answer says nothing about the rate the server accepts
+		return
+	case !response.IsKissOfDeath():
+		state.interval = max(state.interval/2, p.base)
+	case response.KissCode == kissDeny || response.KissCode == kissRstr:
+		state.denied = response.KissCode
+		return
+	case response.KissCode == kissRate:
+		state.interval = min(max(2*state.interval, response.Poll), MaxPoll)
+	default:
+		// other kiss codes are informational
+		return
+	}
+
+	// polls come every base interval and may fire a bit early, half of it is the slack
+	state.next = time.Time{}
+	if state.interval > p.base {
+		state.next = p.now().Add(state.interval - p.base/2)
+	}
+}
+
+// state returns the state of address, creating it on the first use, p.mu must be held
+func (p *Poller) state(address string) *pollState {
+	state, ok := p.servers[address]
+	if !ok {
+		state = &pollState{interval: p.base}
+		p.servers[address] = state
+	}
+	return state
+}
@@ -0,0 +1,104 @@
+package ntpclock
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestKissOfDeathIsReported(t *testing.T) {
+	address := startFakeNTP(t, fakeResponder{kiss: "RATE"})
+
+	sample := PlainQuery(ntp.QueryOptions{Timeout: time.Second})(address)
+	if !errors.Is(sample.Err, ntp.ErrKissOfDeath) || !strings.Contains(sample.Err.Error(), "RATE") {
+		t.Fatalf("expected kiss of death with RATE code, got %v", sample.Err)
+	}
+	if _, err := SelectTruechimers([]Sample{sample}); !errors.Is(err, ErrNoCandidates) {
+		t.Errorf("a kiss must never reach the selection, got %v", err)
+	}
+}
+
+// scriptedQuery answers with kiss codes from the script in order, an empty code is a normal response
+func scriptedQuery(calls *int, script ...string) QueryFunc {
+	return func(address string) Sample {
+		code := script[min(*calls, len(script)-1)]
+		*calls++
+		if code == "" {
+			return Sample{Address: address, Response: &ntp.Response{Stratum: 2}}
+		}
+		return Sample{
+			Address:  address,
+			Response: &ntp.Response{Stratum: 0, KissCode: code, Poll: 64 * time.Second},
+			Err:      ntp.ErrKissOfDeath,
+		}
+	}
+}
+
+func TestPollerRateBacksOff(t *testing.T) {
+	now := time.Now()
+	poller := NewPoller(MinPoll)
+	poller.now = func() time.Time { return now }
+
+	calls := 0
+	query := poller.Wrap(scriptedQuery(&calls, "RATE", "RATE", ""))
+
+	query("a")
+	if interval := poller.Interval("a"); interval != 64*time.Second {
+		t.Fatalf("RATE must raise the interval to the poll of the server, got %v", interval)
+	}
+
+	now = now.Add(MinPoll)
+	if sample := query("a"); !errors.Is(sample.Err, ErrBackoff) || calls != 1 {
+		t.Fatalf("expected backoff without a query, got %v after %d calls", sample.Err, calls)
+	}
+	otherCalls := 0
+	if sample := poller.Wrap(scriptedQuery(&otherCalls, ""))("b"); sample.Err != nil || otherCalls != 1 {
+		t.Fatalf("other servers must not be affected, got %v", sample.Err)
+	}
+
+	now = now.Add(64 * time.Second)
+	query("a")
+	if interval := poller.Interval("a"); interval != 128*time.Second {
+		t.Fatalf("second RATE must double the interval, got %v", interval)
+	}
+
+	now = now.Add(128 * time.Second)
+	query("a")
+	if interval := poller.Interval("a"); interval != 64*time.Second {
+		t.Errorf("normal response must halve the interval, got %v", interval)
+	}
+}
+
+func TestPollerDenyStops(t *testing.T) {
+	for _, code := range []string{"DENY", "RSTR"} {
+		poller := NewPoller(MinPoll)
+		calls := 0
+		query := poller.Wrap(scriptedQuery(&calls, code, ""))
+
+		query("a")
+		for range 3 {
+			if sample := query("a"); !errors.Is(sample.Err, ErrDenied) {
+				t.Errorf("%s: expected ErrDenied, got %v", code, sample.Err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("%s: server must not be queried after denial, got %d queries", code, calls)
+		}
+	}
+}
+
+func TestClampPoll(t *testing.T) {
+	cases := map[time.Duration]time.Duration{
+		time.Second:     MinPoll,
+		time.Minute:     time.Minute,
+		100 * time.Hour: MaxPoll,
+	}
+	for interval, expected := range cases {
+		if result := ClampPoll(interval); result != expected {
+			t.Errorf("ClampPoll(%v): expected %v, got %v", interval, expected, result)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package ntpclock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// defaultQueryTimeout is used when QueryOptions.Timeout is not set, same as in beevik/ntp
+const defaultQueryTimeout = 5 * time.Second
+
+// Sample is the result of querying one NTP server
+type Sample struct {
+	Address  string
+	Response *ntp.Response
+	Err      error
+	// Auth is the authentication method that verified the response, empty if it wasn't authenticated
+	Auth string
+}
+
+// QueryFunc queries one server, it's how the different protocols plug into polling
+type QueryFunc func(address string) Sample
+
+// PlainQuery returns a QueryFunc for plain (S)NTP queries with the given options
+func PlainQuery(opt ntp.QueryOptions) QueryFunc {
+	return func(address string) Sample {
+		return queryOne(address, opt)
+	}
+}
+
+// QueryAll queries every address at the same time and returns samples in the order of addresses
+func QueryAll(addresses []string, query QueryFunc) []Sample {
+	samples := make([]Sample, len(addresses))
+
+	wg := sync.WaitGroup{}
+	for i, address := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			samples[i] = query(address)
+		}()
+	}
+	wg.Wait()
+
+	return samples
+}
+
+// queryOne queries a single server and validates its response
+//
+// a response that fails ntp.Response.Validate is reported as an error, so it never reaches selection
+func queryOne(address string, opt ntp.QueryOptions) Sample {
+	sample := Sample{Address: address}
+
+	response, err := ntp.QueryWithOptions(address, opt)
+	if err != nil {
+		sample.Err = err
+		return sample
+	}
+	sample.Response = response
+
+	err = response.Validate()
+	if errors.Is(err, ntp.ErrKissOfDeath) {
+		// the kiss code tells why: RATE, DENY, RSTR...
+		err = fmt.Errorf("%w: %s", err, response.KissCode)
+	}
+	if err != nil {
+		sample.Err = err
+	}
+	return sample
+}
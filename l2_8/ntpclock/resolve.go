@@ -0,0 +1,164 @@
+package ntpclock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// defaultNTPPort is used when an address has no port
+const defaultNTPPort = 123
+
+// ErrNoAddresses is returned when a name has no addresses of the wanted family
+var ErrNoAddresses = errors.New("no addresses of the wanted family")
+
+// IResolver resolves host names, *net.Resolver implements it
+type IResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ResolvePool expands every address into ip:port of all its A/AAAA records
+//
+// network is "ip", "ip4" or "ip6" and filters the records. Addresses that are already IPs are kept
+// (if they match the family), so pools and single servers can be mixed. Duplicates are dropped
+func ResolvePool(ctx context.Context, resolver IResolver, addresses []string, network string) ([]string, error) {
+	var result []string
+	seen := make(map[string]struct{})
+
+	for _, address := range addresses {
+		host, port := splitHostPort(address)
+
+		var ips []net.IPAddr
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IPAddr{{IP: ip}}
+		} else {
+			var err error
+			ips, err = resolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+			}
+		}
+
+		found := false
+		for _, ip := range ips {
+			if !matchesNetwork(ip.IP, network) {
+				continue
+			}
+			found = true
+
+			hostPort := net.JoinHostPort(ip.String(), port)
+			if _, ok := seen[hostPort]; ok {
+				continue
+			}
+			seen[hostPort] = struct{}{}
+			result = append(result, hostPort)
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: %w (%s)", host, ErrNoAddresses, network)
+		}
+	}
+	return result, nil
+}
+
+// Dialer returns an ntp.QueryOptions.Dialer that dials over network ("udp", "udp4" or "udp6")
+// and sets the TTL (hop limit for IPv6) of outgoing packets, ttl 0 keeps the system default
+//
+// unlike QueryOptions.TTL it works for IPv6 too
+func Dialer(network string, ttl int) func(localAddress, remoteAddress string) (net.Conn, error) {
+	return func(localAddress, remoteAddress string) (net.Conn, error) {
+		remote, err := net.ResolveUDPAddr(network, remoteAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		var local *net.UDPAddr
+		if localAddress != "" {
+			local, err = net.ResolveUDPAddr(network, net.JoinHostPort(localAddress, "0"))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		conn, err := net.DialUDP(network, local, remote)
+		if err != nil {
+			return nil, err
+		}
+		if ttl == 0 {
+			return conn, nil
+		}
+
+		if remote.IP.To4() != nil {
+			err = ipv4.NewConn(conn).SetTTL(ttl)
+		} else {
+			err = ipv6.NewConn(conn).SetHopLimit(ttl)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to set ttl: %w", err)
+		}
+		return conn, nil
+	}
+}
+
+// SourceAddress returns the IP to bind queries to: source itself if it's an IP,
+// otherwise the first address of the interface with this name that matches network
+func SourceAddress(source string, network string) (string, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		if !matchesNetwork(ip, network) {
+			return "", fmt.Errorf("source %s: %w (%s)", source, ErrNoAddresses, network)
+		}
+		return source, nil
+	}
+
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return "", fmt.Errorf("source %q is neither an IP nor an interface: %w", source, err)
+	}
+	addresses, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list addresses of %s: %w", source, err)
+	}
+	for _, address := range addresses {
+		ipNet, ok := address.(*net.IPNet)
+		if !ok || !matchesNetwork(ipNet.IP, network) {
+			continue
+		}
+		// link-local IPv6 can't be used without a zone, skip it
+		if ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP.String(), nil
+	}
+	return "", fmt.Errorf("interface %s: %w (%s)", source, ErrNoAddresses, network)
+}
+
+// matchesNetwork reports whether ip belongs to network: "ip4", "ip6" or anything else for both
+func matchesNetwork(ip net.IP, network string) bool {
+	switch network {
+	case "ip4":
+		return ip.To4() != nil
+	case "ip6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// splitHostPort splits host[:port], the port defaults to 123
+func splitHostPort(address string) (host, port string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// no port: a bare name, IPv4 or IPv6 (possibly in brackets)
+		host = address
+		if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+			host = host[1 : len(host)-1]
+		}
+		port = strconv.Itoa(defaultNTPPort)
+	}
+	return host, port
+}
@@ -0,0 +1,135 @@
+package ntpclock
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// fakeResolver is an in-process DNS stand-in: a fixed map of names to addresses
+type fakeResolver map[string][]string
+
+// LookupIPAddr implements IResolver
+func (r fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	records, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ips := make([]net.IPAddr, 0, len(records))
+	for _, record := range records {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(record)})
+	}
+	return ips, nil
+}
+
+func TestResolvePool(t *testing.T) {
+	resolver := fakeResolver{
+		"pool.test":  {"192.0.2.1", "192.0.2.2", "2001:db8::1"},
+		"other.test": {"192.0.2.2"},
+		"v6.test":    {"2001:db8::2"},
+	}
+	cases := []struct {
+		addresses    []string
+		network      string
+		expected     []string
+		expectsError bool
+	}{
+		{[]string{"pool.test"}, "ip", []string{"192.0.2.1:123", "192.0.2.2:123", "[2001:db8::1]:123"}, false},
+		{[]string{"pool.test:1123"}, "ip4", []string{"192.0.2.1:1123", "192.0.2.2:1123"}, false},
+		{[]string{"pool.test", "other.test"}, "ip4", []string{"192.0.2.1:123", "192.0.2.2:123"}, false},
+		{[]string{"pool.test", "[2001:db8::5]"}, "ip6", []string{"[2001:db8::1]:123", "[2001:db8::5]:123"}, false},
+		{[]string{"v6.test"}, "ip4", nil, true},
+		{[]string{"missing.test"}, "ip", nil, true},
+	}
+	for _, c := range cases {
+		result, err := ResolvePool(context.Background(), resolver, c.addresses, c.network)
+		if (err != nil) != c.expectsError {
+			t.Errorf("%v %s: unexpected error state: %v", c.addresses, c.network, err)
+			continue
+		}
+		if !slices.Equal(result, c.expected) {
+			t.Errorf("%v %s: expected %v, got %v", c.addresses, c.network, c.expected, result)
+		}
+	}
+}
+
+func TestQueryEveryPoolAddress(t *testing.T) {
+	// every loopback address of the pool answers on the same port with its own offset
+	first := startFakeNTPAt(t, "127.0.0.1:0", fakeResponder{offset: time.Second})
+	_, port, _ := net.SplitHostPort(first)
+	startFakeNTPAt(t, net.JoinHostPort("127.0.0.2", port), fakeResponder{offset: time.Second + time.Millisecond})
+	startFakeNTPAt(t, net.JoinHostPort("127.0.0.3", port), fakeResponder{offset: time.Second - time.Millisecond})
+
+	resolver := fakeResolver{"pool.test": {"127.0.0.1", "127.0.0.2", "127.0.0.3", "::1"}}
+	addresses, err := ResolvePool(context.Background(), resolver, []string{net.JoinHostPort("pool.test", port)}, "ip4")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(addresses) != 3 {
+		t.Fatalf("expected 3 IPv4 addresses, got %v", addresses)
+	}
+
+	options := ntp.QueryOptions{Timeout: time.Second, LocalAddress: "127.0.0.1", Dialer: Dialer("udp4", 5)}
+	samples := QueryAll(addresses, PlainQuery(options))
+	for _, sample := range samples {
+		if sample.Err != nil {
+			t.Fatalf("%s: %v", sample.Address, sample.Err)
+		}
+	}
+	result, err := SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("selection: %v", err)
+	}
+	if len(result.Truechimers) != 3 {
+		t.Errorf("expected all 3 addresses to be truechimers: %+v", result)
+	}
+}
+
+func TestDialerIPv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	_ = conn.Close()
+
+	address := startFakeNTPAt(t, "[::1]:0", fakeResponder{offset: time.Second})
+	sample := PlainQuery(ntp.QueryOptions{Timeout: time.Second, Dialer: Dialer("udp6", 5)})(address)
+	if sample.Err != nil {
+		t.Fatalf("query over IPv6 with hop limit: %v", sample.Err)
+	}
+
+	sample = PlainQuery(ntp.QueryOptions{Timeout: time.Second, Dialer: Dialer("udp4", 0)})(address)
+	if sample.Err == nil {
+		t.Error("udp4 dialer must not reach an IPv6 address")
+	}
+}
+
+func TestSourceAddress(t *testing.T) {
+	if source, err := SourceAddress("127.0.0.1", "ip4"); err != nil || source != "127.0.0.1" {
+		t.Errorf("IP source must be kept, got %q, %v", source, err)
+	}
+	if _, err := SourceAddress("127.0.0.1", "ip6"); !errors.Is(err, ErrNoAddresses) {
+		t.Errorf("IPv4 source can't be used for IPv6, got %v", err)
+	}
+	if _, err := SourceAddress("no-such-interface0", "ip"); err == nil {
+		t.Error("expected error for unknown interface")
+	}
+
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		source, err := SourceAddress(iface.Name, "ip4")
+		if err != nil || !net.ParseIP(source).IsLoopback() {
+			t.Errorf("loopback interface %s: expected loopback IPv4, got %q, %v", iface.Name, source, err)
+		}
+		return
+	}
+	t.Log("no loopback interface, interface lookup is not tested")
+}
@@ -0,0 +1,368 @@
+package ntpclock
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Roughtime (Google's original protocol, as run by public servers) constants
+const (
+	roughtimeDefaultPort = "2002"
+	// roughtimeRequestSize is the minimal request size, so a reply is never larger than the request
+	roughtimeRequestSize = 1024
+	roughtimeNonceSize   = 64
+	roughtimeMaxReply    = 4096
+
+	roughtimeResponseContext   = "RoughTime v1 response signature\x00"
+	roughtimeDelegationContext = "RoughTime v1 delegation signature--\x00"
+)
+
+// Roughtime tags are 4 ASCII bytes read as a little-endian uint32
+var (
+	roughtimeTagNONC = roughtimeTag("NONC")
+	roughtimeTagPAD  = roughtimeTag("PAD\xff")
+	roughtimeTagSIG  = roughtimeTag("SIG\x00")
+	roughtimeTagSREP = roughtimeTag("SREP")
+	roughtimeTagCERT = roughtimeTag("CERT")
+	roughtimeTagDELE = roughtimeTag("DELE")
+	roughtimeTagPUBK = roughtimeTag("PUBK")
+	roughtimeTagMINT = roughtimeTag("MINT")
+	roughtimeTagMAXT = roughtimeTag("MAXT")
+	roughtimeTagROOT = roughtimeTag("ROOT")
+	roughtimeTagMIDP = roughtimeTag("MIDP")
+	roughtimeTagRADI = roughtimeTag("RADI")
+	roughtimeTagINDX = roughtimeTag("INDX")
+	roughtimeTagPATH = roughtimeTag("PATH")
+)
+
+// ErrRoughtimeMessage is returned for malformed Roughtime messages
+var ErrRoughtimeMessage = errors.New("malformed roughtime message")
+
+// ErrRoughtimeVerify is returned when a reply fails signature, delegation or Merkle tree checks
+var ErrRoughtimeVerify = errors.New("roughtime reply verification failed")
+
+// ErrRoughtimeInconsistent is returned when the chain proves that some server lies:
+// a server queried later answered with a time that is surely before the time of an earlier one
+var ErrRoughtimeInconsistent = errors.New("roughtime servers are inconsistent")
+
+// RoughtimeServer is a Roughtime server and its long-term public key
+type RoughtimeServer struct {
+	Address   string
+	PublicKey ed25519.PublicKey
+}
+
+// ParseRoughtimeServer parses "host[:port]=base64-public-key"
+func ParseRoughtimeServer(s string) (RoughtimeServer, error) {
+	address, key, ok := strings.Cut(s, "=")
+	if !ok || address == "" {
+		return RoughtimeServer{}, fmt.Errorf("roughtime server %q must be host[:port]=base64-public-key", s)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, roughtimeDefaultPort)
+	}
+
+	// addresses have no "=", so the key keeps its base64 padding after the first one
+	publicKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return RoughtimeServer{}, fmt.Errorf("roughtime server %s: bad public key: %w", address, err)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return RoughtimeServer{}, fmt.Errorf("roughtime server %s: public key must be %d bytes, got %d",
+			address, ed25519.PublicKeySize, len(publicKey))
+	}
+	return RoughtimeServer{Address: address, PublicKey: publicKey}, nil
+}
+
+// RoughtimeResult is the verified answer of one server in a chain
+type RoughtimeResult struct {
+	Server RoughtimeServer
+	// Midpoint is the server time, the true time is within Midpoint ± Radius
+	Midpoint time.Time
+	Radius   time.Duration
+	RTT      time.Duration
+	// Offset is "server - local": Midpoint minus the local time in the middle of the round trip
+	Offset time.Duration
+	Err    error
+
+	// Nonce, Blind and Reply are the chain link: Nonce = SHA-512(previous Reply || Blind),
+	// they let anybody verify a proof of misbehavior later
+	Nonce []byte
+	Blind []byte
+	Reply []byte
+}
+
+// QueryRoughtimeChain queries servers one after another, chaining every nonce to the previous reply
+//
+// every result has either Err or a verified Midpoint. If the verified times contradict the order they were
+// obtained in, ErrRoughtimeInconsistent names the pair, one of the two servers lies
+func QueryRoughtimeChain(servers []RoughtimeServer, timeout time.Duration) ([]RoughtimeResult, error) {
+	results := make([]RoughtimeResult, len(servers))
+
+	var previous []byte
+	for i, server := range servers {
+		result := &results[i]
+		result.Server = server
+
+		result.Blind = make([]byte, roughtimeNonceSize)
+		if _, err := rand.Read(result.Blind); err != nil {
+			return nil, err
+		}
+		nonce := sha512.Sum512(append(append([]byte{}, previous...), result.Blind...))
+		result.Nonce = nonce[:]
+
+		queryRoughtime(result, timeout)
+		if result.Err == nil {
+			previous = result.Reply
+		}
+	}
+
+	return results, checkRoughtimeChain(results)
+}
+
+// checkRoughtimeChain finds a pair of verified results where the later one is surely earlier in time
+func checkRoughtimeChain(results []RoughtimeResult) error {
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		for j := i + 1; j < len(results); j++ {
+			if results[j].Err != nil {
+				continue
+			}
+			earliestFirst := results[i].Midpoint.Add(-results[i].Radius)
+			latestSecond := results[j].Midpoint.Add(results[j].Radius)
+			if latestSecond.Before(earliestFirst) {
+				return fmt.Errorf("%w: %s answered %v after %s answered %v",
+					ErrRoughtimeInconsistent, results[j].Server.Address, results[j].Midpoint.UTC(),
+					results[i].Server.Address, results[i].Midpoint.UTC())
+			}
+		}
+	}
+	return nil
+}
+
+// queryRoughtime sends one request with result.Nonce and fills in the verified time
+func queryRoughtime(result *RoughtimeResult, timeout time.Duration) {
+	conn, err := net.Dial("udp", result.Server.Address)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	request := newRoughtimeRequest(result.Nonce)
+	sent := time.Now()
+	if _, err = conn.Write(request); err != nil {
+		result.Err = err
+		return
+	}
+
+	buf := make([]byte, roughtimeMaxReply)
+	n, err := conn.Read(buf)
+	received := time.Now()
+	if err != nil {
+		result.Err = err
+		return
+	}
+	result.Reply = buf[:n]
+	result.RTT = received.Sub(sent)
+
+	result.Midpoint, result.Radius, result.Err = verifyRoughtimeReply(result.Reply, result.Nonce, result.Server.PublicKey)
+	if result.Err == nil {
+		result.Offset = result.Midpoint.Sub(sent.Add(result.RTT / 2))
+	}
+}
+
+// newRoughtimeRequest builds a request with the nonce padded to roughtimeRequestSize
+func newRoughtimeRequest(nonce []byte) []byte {
+	// header of a 2-tag message: count, one offset, two tags
+	padding := roughtimeRequestSize - 4*4 - len(nonce)
+	return encodeRoughtimeMessage(map[uint32][]byte{
+		roughtimeTagNONC: nonce,
+		roughtimeTagPAD:  make([]byte, padding),
+	})
+}
+
+// verifyRoughtimeReply checks the delegation, the signature and the Merkle path of the nonce
+func verifyRoughtimeReply(reply []byte, nonce []byte, publicKey ed25519.PublicKey) (time.Time, time.Duration, error) {
+	message, err := parseRoughtimeMessage(reply)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	values, err := message.required(roughtimeTagSIG, roughtimeTagSREP, roughtimeTagCERT, roughtimeTagINDX, roughtimeTagPATH)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	signature, signedResponse, certBytes, index, path := values[0], values[1], values[2], values[3], values[4]
+
+	// the long-term key delegates signing to an online key for [MINT, MAXT]
+	cert, err := parseRoughtimeMessage(certBytes)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	values, err = cert.required(roughtimeTagSIG, roughtimeTagDELE)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	certSignature, delegationBytes := values[0], values[1]
+	if !ed25519.Verify(publicKey, append([]byte(roughtimeDelegationContext), delegationBytes...), certSignature) {
+		return time.Time{}, 0, fmt.Errorf("%w: bad delegation signature", ErrRoughtimeVerify)
+	}
+	delegation, err := parseRoughtimeMessage(delegationBytes)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	values, err = delegation.required(roughtimeTagPUBK, roughtimeTagMINT, roughtimeTagMAXT)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	onlineKey, minTime, maxTime := values[0], values[1], values[2]
+	if len(onlineKey) != ed25519.PublicKeySize || len(minTime) != 8 || len(maxTime) != 8 {
+		return time.Time{}, 0, fmt.Errorf("%w: bad delegation", ErrRoughtimeMessage)
+	}
+
+	if !ed25519.Verify(onlineKey, append([]byte(roughtimeResponseContext), signedResponse...), signature) {
+		return time.Time{}, 0, fmt.Errorf("%w: bad response signature", ErrRoughtimeVerify)
+	}
+
+	response, err := parseRoughtimeMessage(signedResponse)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	values, err = response.required(roughtimeTagROOT, roughtimeTagMIDP, roughtimeTagRADI)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	root, midpointBytes, radiusBytes := values[0], values[1], values[2]
+	if len(midpointBytes) != 8 || len(radiusBytes) != 4 || len(index) != 4 || len(path)%sha512.Size != 0 {
+		return time.Time{}, 0, fmt.Errorf("%w: bad field sizes", ErrRoughtimeMessage)
+	}
+
+	if !bytes.Equal(roughtimeMerkleRoot(nonce, binary.LittleEndian.Uint32(index), path), root) {
+		return time.Time{}, 0, fmt.Errorf("%w: nonce is not in the signed Merkle tree", ErrRoughtimeVerify)
+	}
+
+	midpoint := binary.LittleEndian.Uint64(midpointBytes)
+	if midpoint < binary.LittleEndian.Uint64(minTime) || midpoint > binary.LittleEndian.Uint64(maxTime) {
+		return time.Time{}, 0, fmt.Errorf("%w: midpoint is outside of the delegation validity", ErrRoughtimeVerify)
+	}
+
+	radius := time.Duration(binary.LittleEndian.Uint32(radiusBytes)) * time.Microsecond
+	return time.UnixMicro(int64(midpoint)), radius, nil
+}
+
+// roughtimeMerkleRoot computes the tree root from the leaf of nonce and the path of sibling hashes
+func roughtimeMerkleRoot(nonce []byte, index uint32, path []byte) []byte {
+	hash := sha512.Sum512(append([]byte{0}, nonce...))
+	for len(path) > 0 {
+		sibling := path[:sha512.Size]
+		path = path[sha512.Size:]
+
+		node := []byte{1}
+		if index&1 == 0 {
+			node = append(append(node, hash[:]...), sibling...)
+		} else {
+			node = append(append(node, sibling...), hash[:]...)
+		}
+		hash = sha512.Sum512(node)
+		index >>= 1
+	}
+	return hash[:]
+}
+
+// roughtimeMessage is a parsed tag-value message
+type roughtimeMessage map[uint32][]byte
+
+// encodeRoughtimeMessage encodes values sorted by tag, every value length must be a multiple of 4
+func encodeRoughtimeMessage(values map[uint32][]byte) []byte {
+	tags := make([]uint32, 0, len(values))
+	for tag := range values {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := uint32(0)
+	for _, tag := range tags[:len(tags)-1] {
+		offset += uint32(len(values[tag]))
+		out = binary.LittleEndian.AppendUint32(out, offset)
+	}
+	for _, tag := range tags {
+		out = binary.LittleEndian.AppendUint32(out, tag)
+	}
+	for _, tag := range tags {
+		out = append(out, values[tag]...)
+	}
+	return out
+}
+
+// parseRoughtimeMessage parses the header and slices values without copying
+func parseRoughtimeMessage(b []byte) (roughtimeMessage, error) {
+	if len(b) < 4 || len(b)%4 != 0 {
+		return nil, fmt.Errorf("%w: bad length %d", ErrRoughtimeMessage, len(b))
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	if count == 0 || count > len(b)/8 {
+		return nil, fmt.Errorf("%w: bad tag count %d", ErrRoughtimeMessage, count)
+	}
+
+	headerSize := 4 * 2 * count
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("%w: short header", ErrRoughtimeMessage)
+	}
+	values := b[headerSize:]
+
+	// offsets[i] is where value i starts, the first one starts at 0
+	offsets := make([]int, count+1)
+	for i := 1; i < count; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	offsets[count] = len(values)
+
+	message := make(roughtimeMessage, count)
+	var previousTag uint32
+	for i := 0; i < count; i++ {
+		tag := binary.LittleEndian.Uint32(b[4*count+4*i:])
+		if i > 0 && tag <= previousTag {
+			return nil, fmt.Errorf("%w: tags are not sorted", ErrRoughtimeMessage)
+		}
+		previousTag = tag
+
+		start, end := offsets[i], offsets[i+1]
+		if start < 0 || start%4 != 0 || start > end || end > len(values) {
+			return nil, fmt.Errorf("%w: bad offsets", ErrRoughtimeMessage)
+		}
+		message[tag] = values[start:end]
+	}
+	return message, nil
+}
+
+// required returns values of tags in order, it fails if any of them is missing
+func (m roughtimeMessage) required(tags ...uint32) ([][]byte, error) {
+	values := make([][]byte, len(tags))
+	for i, tag := range tags {
+		value, ok := m[tag]
+		if !ok {
+			name := binary.LittleEndian.AppendUint32(nil, tag)
+			return nil, fmt.Errorf("%w: no %q tag", ErrRoughtimeMessage, strings.TrimRight(string(name), "\x00\xff"))
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// roughtimeTag converts a 4-character tag name to its wire value
+func roughtimeTag(name string) uint32 {
+	return binary.LittleEndian.Uint32([]byte(name))
+}
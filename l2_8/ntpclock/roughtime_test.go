@@ -0,0 +1,239 @@
+package ntpclock
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRoughtime is a stand-in Roughtime server: the local clock shifted by offset
+type fakeRoughtime struct {
+	offset time.Duration
+	radius time.Duration
+	// corrupt flips a bit of the signed response after signing
+	corrupt atomic.Bool
+
+	publicKey ed25519.PublicKey
+	onlineKey ed25519.PrivateKey
+	cert      []byte
+}
+
+// startFakeRoughtime starts a loopback Roughtime server and returns it with its address
+func startFakeRoughtime(t *testing.T, offset time.Duration) (*fakeRoughtime, RoughtimeServer) {
+	t.Helper()
+
+	publicKey, rootKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onlinePublic, onlineKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := uint64(time.Now().UnixMicro())
+	delegation := encodeRoughtimeMessage(map[uint32][]byte{
+		roughtimeTagPUBK: onlinePublic,
+		roughtimeTagMINT: binary.LittleEndian.AppendUint64(nil, now-uint64(time.Hour/time.Microsecond)),
+		roughtimeTagMAXT: binary.LittleEndian.AppendUint64(nil, now+uint64(time.Hour/time.Microsecond)),
+	})
+	cert := encodeRoughtimeMessage(map[uint32][]byte{
+		roughtimeTagDELE: delegation,
+		roughtimeTagSIG:  ed25519.Sign(rootKey, append([]byte(roughtimeDelegationContext), delegation...)),
+	})
+
+	server := &fakeRoughtime{
+		offset:    offset,
+		radius:    time.Second,
+		publicKey: publicKey,
+		onlineKey: onlineKey,
+		cert:      cert,
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go server.serve(conn)
+
+	return server, RoughtimeServer{Address: conn.LocalAddr().String(), PublicKey: publicKey}
+}
+
+func (f *fakeRoughtime) serve(conn net.PacketConn) {
+	buf := make([]byte, roughtimeMaxReply)
+	for {
+		n, remote, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < roughtimeRequestSize {
+			continue
+		}
+		request, err := parseRoughtimeMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		nonce, ok := request[roughtimeTagNONC]
+		if !ok {
+			continue
+		}
+		_, _ = conn.WriteTo(f.reply(nonce), remote)
+	}
+}
+
+// reply answers nonce as the second leaf of a two-leaf tree, so the Merkle path is not empty
+func (f *fakeRoughtime) reply(nonce []byte) []byte {
+	decoy := sha512.Sum512(append([]byte{0}, make([]byte, roughtimeNonceSize)...))
+	leaf := sha512.Sum512(append([]byte{0}, nonce...))
+	root := sha512.Sum512(append(append([]byte{1}, decoy[:]...), leaf[:]...))
+
+	midpoint := time.Now().Add(f.offset).UnixMicro()
+	signed := encodeRoughtimeMessage(map[uint32][]byte{
+		roughtimeTagROOT: root[:],
+		roughtimeTagMIDP: binary.LittleEndian.AppendUint64(nil, uint64(midpoint)),
+		roughtimeTagRADI: binary.LittleEndian.AppendUint32(nil, uint32(f.radius/time.Microsecond)),
+	})
+	signature := ed25519.Sign(f.onlineKey, append([]byte(roughtimeResponseContext), signed...))
+	if f.corrupt.Load() {
+		signed = append([]byte{}, signed...)
+		signed[len(signed)-1] ^= 1
+	}
+
+	return encodeRoughtimeMessage(map[uint32][]byte{
+		roughtimeTagSIG:  signature,
+		roughtimeTagSREP: signed,
+		roughtimeTagCERT: f.cert,
+		roughtimeTagINDX: binary.LittleEndian.AppendUint32(nil, 1),
+		roughtimeTagPATH: decoy[:],
+	})
+}
+
+func TestRoughtimeMessageRoundTrip(t *testing.T) {
+	encoded := encodeRoughtimeMessage(map[uint32][]byte{
+		roughtimeTagMIDP: {1, 2, 3, 4, 5, 6, 7, 8},
+		roughtimeTagRADI: {9, 10, 11, 12},
+		roughtimeTagPATH: {},
+	})
+	message, err := parseRoughtimeMessage(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message) != 3 || len(message[roughtimeTagMIDP]) != 8 || message[roughtimeTagRADI][3] != 12 ||
+		len(message[roughtimeTagPATH]) != 0 {
+		t.Errorf("unexpected message %v", message)
+	}
+
+	request := newRoughtimeRequest(make([]byte, roughtimeNonceSize))
+	if len(request) != roughtimeRequestSize {
+		t.Errorf("request is %d bytes, want %d", len(request), roughtimeRequestSize)
+	}
+
+	for _, bad := range [][]byte{nil, {1, 0, 0}, {5, 0, 0, 0}, encoded[:12]} {
+		if _, err := parseRoughtimeMessage(bad); !errors.Is(err, ErrRoughtimeMessage) {
+			t.Errorf("parse %v: expected ErrRoughtimeMessage, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseRoughtimeServer(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))
+
+	server, err := ParseRoughtimeServer("roughtime.example.com=" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Address != "roughtime.example.com:2002" || len(server.PublicKey) != ed25519.PublicKeySize {
+		t.Errorf("unexpected server %+v", server)
+	}
+
+	for _, bad := range []string{"example.com", "=" + key, "example.com=short", "example.com=" + key[:20]} {
+		if _, err := ParseRoughtimeServer(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestQueryRoughtimeChain(t *testing.T) {
+	_, first := startFakeRoughtime(t, 0)
+	_, second := startFakeRoughtime(t, 200*time.Millisecond)
+
+	results, err := QueryRoughtimeChain([]RoughtimeServer{first, second}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("server %d: %v", i, result.Err)
+		}
+		if result.Radius != time.Second {
+			t.Errorf("server %d: radius %v, want 1s", i, result.Radius)
+		}
+	}
+	if offset := results[1].Offset; offset < 100*time.Millisecond || offset > 300*time.Millisecond {
+		t.Errorf("second offset %v, want about 200ms", offset)
+	}
+
+	// the second nonce commits to the first reply
+	want := sha512.Sum512(append(append([]byte{}, results[0].Reply...), results[1].Blind...))
+	if string(results[1].Nonce) != string(want[:]) {
+		t.Error("second nonce is not chained to the first reply")
+	}
+}
+
+func TestQueryRoughtimeChainDetectsLiar(t *testing.T) {
+	// the liar is half an hour ahead, the honest server answers after it with a time that is surely earlier
+	_, liar := startFakeRoughtime(t, 30*time.Minute)
+	_, honest := startFakeRoughtime(t, 0)
+
+	results, err := QueryRoughtimeChain([]RoughtimeServer{liar, honest}, time.Second)
+	if !errors.Is(err, ErrRoughtimeInconsistent) {
+		t.Fatalf("expected ErrRoughtimeInconsistent, got %v", err)
+	}
+	if !strings.Contains(err.Error(), liar.Address) || !strings.Contains(err.Error(), honest.Address) {
+		t.Errorf("error should name both servers: %v", err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Errorf("both replies should be verified: %+v", results)
+	}
+}
+
+func TestQueryRoughtimeVerification(t *testing.T) {
+	fake, server := startFakeRoughtime(t, 0)
+
+	// a reply signed by another key
+	other, _, _ := ed25519.GenerateKey(nil)
+	results, _ := QueryRoughtimeChain([]RoughtimeServer{{Address: server.Address, PublicKey: other}}, time.Second)
+	if !errors.Is(results[0].Err, ErrRoughtimeVerify) {
+		t.Errorf("wrong key: expected ErrRoughtimeVerify, got %v", results[0].Err)
+	}
+
+	// a reply changed after signing
+	fake.corrupt.Store(true)
+	results, _ = QueryRoughtimeChain([]RoughtimeServer{server}, time.Second)
+	if !errors.Is(results[0].Err, ErrRoughtimeVerify) {
+		t.Errorf("corrupted reply: expected ErrRoughtimeVerify, got %v", results[0].Err)
+	}
+}
+
+func TestRoughtimeMerkleRoot(t *testing.T) {
+	nonce := []byte("nonce")
+	leaf := sha512.Sum512(append([]byte{0}, nonce...))
+	sibling := make([]byte, sha512.Size)
+
+	left := sha512.Sum512(append(append([]byte{1}, leaf[:]...), sibling...))
+	if got := roughtimeMerkleRoot(nonce, 0, sibling); string(got) != string(left[:]) {
+		t.Error("index 0: leaf must be the left child")
+	}
+	right := sha512.Sum512(append(append([]byte{1}, sibling...), leaf[:]...))
+	if got := roughtimeMerkleRoot(nonce, 1, sibling); string(got) != string(right[:]) {
+		t.Error("index 1: leaf must be the right child")
+	}
+}
@@ -0,0 +1,155 @@
+package ntpclock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// minDispersion is MINDISP from RFC 5905, the smallest correctness interval half-width we trust
+const minDispersion = 5 * time.Millisecond
+
+// ErrNoMajority is returned when less than a half of servers agree on the time
+var ErrNoMajority = errors.New("no majority of servers agree on the time")
+
+// ErrNoCandidates is returned when no server gave a usable response
+var ErrNoCandidates = errors.New("no usable responses")
+
+// Consensus is the combined result of the selection algorithm
+type Consensus struct {
+	// Offset is the combined clock offset of truechimers, weighted by 1/root distance
+	Offset time.Duration
+	// ErrorBound is the half-width of the intersection interval: true offset is Offset ± ErrorBound
+	ErrorBound time.Duration
+	// Low and High are the bounds of the intersection interval
+	Low  time.Duration
+	High time.Duration
+	// Truechimers are indices (in samples) of servers that survived the selection
+	Truechimers []int
+	// Falsetickers are indices (in samples) of servers that were dropped
+	Falsetickers []int
+}
+
+// endpoint is an edge or a midpoint of a correctness interval
+//
+// kind is -1 for the lower edge, 0 for the midpoint and +1 for the upper edge
+type endpoint struct {
+	value time.Duration
+	kind  int
+}
+
+// SelectTruechimers runs the Marzullo-style intersection algorithm from RFC 5905 (A.5.5.1)
+//
+// every valid sample gives a correctness interval [offset - root distance, offset + root distance].
+// we look for the smallest number of falsetickers f (f < n/2) such that n-f intervals share a common part,
+// then every sample whose offset lies outside that part is a falseticker.
+//
+// samples with Err set are ignored: they are neither truechimers nor falsetickers
+func SelectTruechimers(samples []Sample) (Consensus, error) {
+	var candidates []int
+	for i, sample := range samples {
+		if sample.Err == nil && sample.Response != nil {
+			candidates = append(candidates, i)
+		}
+	}
+	n := len(candidates)
+	if n == 0 {
+		return Consensus{}, ErrNoCandidates
+	}
+
+	endpoints := make([]endpoint, 0, 3*n)
+	for _, i := range candidates {
+		offset, distance := sampleInterval(samples[i])
+		endpoints = append(endpoints,
+			endpoint{offset - distance, -1},
+			endpoint{offset, 0},
+			endpoint{offset + distance, +1},
+		)
+	}
+	sort.Slice(endpoints, func(a, b int) bool {
+		if endpoints[a].value != endpoints[b].value {
+			return endpoints[a].value < endpoints[b].value
+		}
+		// on equal values lower edges go first, so touching intervals still intersect
+		return endpoints[a].kind < endpoints[b].kind
+	})
+
+	var low, high time.Duration
+	found := false
+
+	for allow := 0; 2*allow < n; allow++ {
+		midpoints := 0
+
+		// walk up: the lowest point covered by at least n-allow intervals
+		chime := 0
+		for _, e := range endpoints {
+			chime -= e.kind
+			if chime >= n-allow {
+				low = e.value
+				break
+			}
+			if e.kind == 0 {
+				midpoints++
+			}
+		}
+
+		// walk down: the highest point covered by at least n-allow intervals
+		chime = 0
+		for i := len(endpoints) - 1; i >= 0; i-- {
+			e := endpoints[i]
+			chime += e.kind
+			if chime >= n-allow {
+				high = e.value
+				break
+			}
+			if e.kind == 0 {
+				midpoints++
+			}
+		}
+
+		// more midpoints outside than allowed falsetickers - try allowing one more
+		if midpoints > allow || low > high {
+			continue
+		}
+		found = true
+		break
+	}
+
+	if !found {
+		return Consensus{}, fmt.Errorf("%w: %d servers answered", ErrNoMajority, n)
+	}
+
+	result := Consensus{
+		Low:        low,
+		High:       high,
+		ErrorBound: (high - low) / 2,
+	}
+
+	// clock combine: weight every truechimer by its inverse root distance
+	var weightedSum, weights float64
+	for _, i := range candidates {
+		offset, distance := sampleInterval(samples[i])
+		if offset < low || offset > high {
+			result.Falsetickers = append(result.Falsetickers, i)
+			continue
+		}
+		result.Truechimers = append(result.Truechimers, i)
+
+		weight := 1 / distance.Seconds()
+		weightedSum += offset.Seconds() * weight
+		weights += weight
+	}
+	result.Offset = time.Duration(weightedSum / weights * float64(time.Second))
+
+	return result, nil
+}
+
+// sampleInterval returns the offset of the sample and the half-width of its correctness interval
+func sampleInterval(sample Sample) (offset time.Duration, distance time.Duration) {
+	distance = sample.Response.RootDistance
+	if distance < minDispersion {
+		distance = minDispersion
+	}
+	return sample.Response.ClockOffset, distance
+}
@@ -0,0 +1,134 @@
+package ntpclock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// sampleWith builds a valid sample with the given offset and root distance
+func sampleWith(offset, distance time.Duration) Sample {
+	return Sample{
+		Address:  "test",
+		Response: &ntp.Response{ClockOffset: offset, RootDistance: distance},
+	}
+}
+
+func TestSelectTruechimers(t *testing.T) {
+	cases := []struct {
+		name         string
+		samples      []Sample
+		falsetickers []int
+		expectsError bool
+	}{
+		{
+			name:    "single server",
+			samples: []Sample{sampleWith(time.Second, 10*time.Millisecond)},
+		},
+		{
+			name: "all agree",
+			samples: []Sample{
+				sampleWith(0, 10*time.Millisecond),
+				sampleWith(2*time.Millisecond, 10*time.Millisecond),
+				sampleWith(-3*time.Millisecond, 10*time.Millisecond),
+			},
+		},
+		{
+			name: "one falseticker out of three",
+			samples: []Sample{
+				sampleWith(0, 10*time.Millisecond),
+				sampleWith(10*time.Second, 10*time.Millisecond),
+				sampleWith(time.Millisecond, 10*time.Millisecond),
+			},
+			falsetickers: []int{1},
+		},
+		{
+			name: "two falsetickers out of five",
+			samples: []Sample{
+				sampleWith(-time.Hour, 10*time.Millisecond),
+				sampleWith(0, 10*time.Millisecond),
+				sampleWith(time.Millisecond, 10*time.Millisecond),
+				sampleWith(-time.Millisecond, 10*time.Millisecond),
+				sampleWith(time.Hour, 10*time.Millisecond),
+			},
+			falsetickers: []int{0, 4},
+		},
+		{
+			name: "errored samples are skipped",
+			samples: []Sample{
+				{Address: "broken", Err: errors.New("timeout")},
+				sampleWith(0, 10*time.Millisecond),
+			},
+		},
+		{
+			name: "two servers disagree",
+			samples: []Sample{
+				sampleWith(0, 10*time.Millisecond),
+				sampleWith(time.Minute, 10*time.Millisecond),
+			},
+			expectsError: true,
+		},
+		{
+			name:         "nothing to select from",
+			samples:      []Sample{{Address: "broken", Err: errors.New("timeout")}},
+			expectsError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := SelectTruechimers(c.samples)
+			if c.expectsError {
+				if err == nil {
+					t.Fatalf("expected error, got consensus %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(result.Falsetickers) != len(c.falsetickers) {
+				t.Fatalf("falsetickers: expected %v, got %v", c.falsetickers, result.Falsetickers)
+			}
+			for i := range c.falsetickers {
+				if result.Falsetickers[i] != c.falsetickers[i] {
+					t.Fatalf("falsetickers: expected %v, got %v", c.falsetickers, result.Falsetickers)
+				}
+			}
+
+			if result.Offset < result.Low || result.Offset > result.High {
+				t.Errorf("offset %v is outside of intersection [%v, %v]", result.Offset, result.Low, result.High)
+			}
+		})
+	}
+}
+
+// TestQueryAllRejectsFalseticker queries real loopback responders, one of them is 10 minutes off
+func TestQueryAllRejectsFalseticker(t *testing.T) {
+	addresses := []string{
+		startFakeNTP(t, fakeResponder{}),
+		startFakeNTP(t, fakeResponder{offset: 10 * time.Minute}),
+		startFakeNTP(t, fakeResponder{offset: time.Millisecond}),
+	}
+
+	samples := QueryAll(addresses, PlainQuery(ntp.QueryOptions{Timeout: time.Second}))
+	for _, sample := range samples {
+		if sample.Err != nil {
+			t.Fatalf("query %s: %v", sample.Address, sample.Err)
+		}
+	}
+
+	result, err := SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Falsetickers) != 1 || result.Falsetickers[0] != 1 {
+		t.Fatalf("expected server 1 to be a falseticker, got %v", result.Falsetickers)
+	}
+	if result.Offset > 100*time.Millisecond || result.Offset < -100*time.Millisecond {
+		t.Errorf("consensus offset is too far from zero: %v", result.Offset)
+	}
+}
@@ -0,0 +1,127 @@
+package ntpclock
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// serverPrecision is log2 of the clock precision announced by the server, 2^-20 s ≈ 1µs
+const serverPrecision = -20
+
+// Server answers SNTPv4 (RFC 4330) client requests from its clock
+//
+// if Keys are set, requests that carry a MAC are answered with a MAC made by the same key,
+// requests with an unknown key or a bad MAC get a crypto-NAK (a MAC with key id 0 and no digest)
+type Server struct {
+	Clock IServerClock
+	Keys  map[uint16]SymmetricKey
+
+	// Stratum and ReferenceID override the values of the clock, zero means keep the clock's
+	// (an unsynchronized clock keeps stratum 16 anyway)
+	Stratum     uint8
+	ReferenceID uint32
+}
+
+// NewServer creates a server that hands out the time of clock
+func NewServer(clock IServerClock) *Server {
+	return &Server{Clock: clock}
+}
+
+// Serve answers requests on conn until it is closed, the closing is not an error
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		received, _ := s.Clock.ServerTime()
+
+		response, err := s.respond(buf[:n], received)
+		if err != nil {
+			// not a client request - SNTP servers silently drop those
+			continue
+		}
+
+		_, _ = conn.WriteTo(response, addr)
+	}
+}
+
+// respond builds the response datagram for one request received at the given time
+//
+// per RFC 4330 the version and poll are copied from the request and
+// the transmit timestamp of the request becomes the origin timestamp of the response
+func (s *Server) respond(request []byte, received time.Time) ([]byte, error) {
+	requestPacket, err := parseNtpPacket(request)
+	if err != nil {
+		return nil, err
+	}
+	if requestPacket.mode() != modeClient {
+		return nil, errors.New("not a client request")
+	}
+
+	version := requestPacket.version()
+	if version < 1 || version > 4 {
+		version = 4
+	}
+
+	transmit, state := s.Clock.ServerTime()
+	// kisses (stratum 0) and unsynchronized clocks keep their stratum
+	if s.Stratum != 0 && state.Stratum != 0 && state.Leap != ntp.LeapNotInSync {
+		state.Stratum = s.Stratum
+	}
+	if s.ReferenceID != 0 {
+		state.ReferenceID = s.ReferenceID
+	}
+
+	response := ntpPacket{
+		Stratum:        state.Stratum,
+		Poll:           requestPacket.Poll,
+		Precision:      serverPrecision,
+		RootDelay:      toNtpShort(state.RootDelay),
+		RootDispersion: toNtpShort(state.RootDispersion),
+		ReferenceID:    state.ReferenceID,
+		OriginTime:     requestPacket.TransmitTime,
+		ReceiveTime:    toNtpTimestamp(received),
+		TransmitTime:   toNtpTimestamp(transmit),
+	}
+	if !state.ReferenceTime.IsZero() {
+		response.ReferenceTime = toNtpTimestamp(state.ReferenceTime)
+	}
+	response.setLiVnMode(uint8(state.Leap), version, modeServer)
+
+	packet := response.marshal()
+	if len(request) > ntpHeaderSize && s.Keys != nil {
+		packet = s.appendMAC(packet, request)
+	}
+	return packet, nil
+}
+
+// appendMAC signs the response with the key the request was signed with
+func (s *Server) appendMAC(response []byte, request []byte) []byte {
+	mac := request[ntpHeaderSize:]
+	if len(mac) < 4 {
+		return binary.BigEndian.AppendUint32(response, 0)
+	}
+
+	keyID := binary.BigEndian.Uint32(mac[:4])
+	key, ok := s.Keys[uint16(keyID)]
+	if !ok || keyID > 0xffff {
+		return binary.BigEndian.AppendUint32(response, 0)
+	}
+	expected := key.digest(request[:ntpHeaderSize])
+	if subtle.ConstantTimeCompare(expected, mac[4:]) != 1 {
+		return binary.BigEndian.AppendUint32(response, 0)
+	}
+
+	response = binary.BigEndian.AppendUint32(response, keyID)
+	return append(response, key.digest(response[:ntpHeaderSize])...)
+}
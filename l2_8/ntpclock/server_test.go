@@ -0,0 +1,92 @@
+package ntpclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func TestNtpTimestampRoundTrip(t *testing.T) {
+	now := time.Now()
+	back := fromNtpTimestamp(toNtpTimestamp(now))
+	if diff := back.Sub(now); diff > time.Nanosecond || diff < -time.Nanosecond {
+		t.Errorf("round trip changed time by %v", diff)
+	}
+}
+
+func TestParseReferenceID(t *testing.T) {
+	cases := []struct {
+		input        string
+		expected     uint32
+		expectsError bool
+	}{
+		{"LOCL", 0x4c4f434c, false},
+		{"GPS", 0x47505300, false},
+		{"10.0.0.1", 0x0a000001, false},
+		{"TOOLONG", 0, true},
+		{"", 0, true},
+		{"::1", 0, true},
+	}
+	for _, c := range cases {
+		result, err := ParseReferenceID(c.input)
+		if (err != nil) != c.expectsError {
+			t.Errorf("ParseReferenceID(%q): unexpected error state: %v", c.input, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("ParseReferenceID(%q): expected %#x, got %#x", c.input, c.expected, result)
+		}
+	}
+}
+
+func TestServeLocalClock(t *testing.T) {
+	address := startServer(t, NewLocalClock())
+
+	response, err := ntp.QueryWithOptions(address, ntp.QueryOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if err = response.Validate(); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.Stratum != 1 || response.ReferenceString() != ".LOCL." {
+		t.Errorf("unexpected stratum %d and reference %s", response.Stratum, response.ReferenceString())
+	}
+	if response.ClockOffset > 50*time.Millisecond || response.ClockOffset < -50*time.Millisecond {
+		t.Errorf("local clock must have no offset, got %v", response.ClockOffset)
+	}
+}
+
+func TestServeUpstreamClock(t *testing.T) {
+	upstreamAddress := startFakeNTP(t, fakeResponder{offset: 3 * time.Second})
+	clock := NewClock([]string{upstreamAddress}, PlainQuery(ntp.QueryOptions{Timeout: time.Second}))
+	address := startServer(t, clock)
+
+	// not synchronized yet - clients must refuse the time
+	response, err := ntp.QueryWithOptions(address, ntp.QueryOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if response.Validate() == nil {
+		t.Fatal("unsynchronized server must not give valid responses")
+	}
+
+	if err = clock.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	response, err = ntp.QueryWithOptions(address, ntp.QueryOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if err = response.Validate(); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.Stratum != 3 || response.ReferenceString() != "127.0.0.1" {
+		t.Errorf("unexpected stratum %d and reference %s", response.Stratum, response.ReferenceString())
+	}
+	if diff := response.ClockOffset - 3*time.Second; diff > 50*time.Millisecond || diff < -50*time.Millisecond {
+		t.Errorf("expected offset of about 3s, got %v", response.ClockOffset)
+	}
+}
@@ -0,0 +1,71 @@
+package ntpclock
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// phi is the frequency tolerance of a clock (15 ppm, RFC 5905): dispersion grows by phi every second
+const phi = 15e-6
+
+// localReferenceID is "LOCL", used by a server that trusts its own clock
+const localReferenceID = 'L'<<24 | 'O'<<16 | 'C'<<8 | 'L'
+
+// ClockState describes the quality of the time a server hands out
+type ClockState struct {
+	Leap           ntp.LeapIndicator
+	Stratum        uint8
+	ReferenceID    uint32
+	ReferenceTime  time.Time
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+}
+
+// IServerClock is the time source of the SNTP server
+type IServerClock interface {
+	// ServerTime returns current time and the state that goes into the response header
+	ServerTime() (time.Time, ClockState)
+}
+
+// LocalClock serves the local system time as is, as a stratum 1 "LOCL" clock
+type LocalClock struct{}
+
+// NewLocalClock creates a clock that trusts time.Now
+func NewLocalClock() *LocalClock {
+	return &LocalClock{}
+}
+
+// ServerTime returns time.Now, the local clock is its own reference
+func (c *LocalClock) ServerTime() (time.Time, ClockState) {
+	now := time.Now()
+	return now, ClockState{
+		Leap:          ntp.LeapNoWarning,
+		Stratum:       1,
+		ReferenceID:   localReferenceID,
+		ReferenceTime: now,
+	}
+}
+
+// addressReferenceID builds the reference id of a stratum 2+ server from its upstream address
+//
+// IPv4 is used as is, IPv6 is replaced by the first 4 bytes of its MD5 hash (RFC 5905)
+func addressReferenceID(address string) uint32 {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ipAddress, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return 0
+	}
+
+	if ip4 := ipAddress.IP.To4(); ip4 != nil {
+		return binary.BigEndian.Uint32(ip4)
+	}
+	sum := md5.Sum(ipAddress.IP.To16())
+	return binary.BigEndian.Uint32(sum[:4])
+}
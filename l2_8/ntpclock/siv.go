@@ -0,0 +1,184 @@
+package ntpclock
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// sivBlockSize is the AES block size, also the size of the synthetic IV
+const sivBlockSize = aes.BlockSize
+
+// ErrSIVAuthFailed is returned when a ciphertext doesn't match its synthetic IV
+var ErrSIVAuthFailed = errors.New("aes-siv: message authentication failed")
+
+// aesSIV is AEAD_AES_SIV_CMAC_256 (RFC 5297), the mandatory AEAD algorithm of NTS
+//
+// the 32-byte key is split in two: the first half is the CMAC key of S2V,
+// the second half is the CTR key. The output is V || C, where V is the synthetic IV (the tag)
+type aesSIV struct {
+	mac cipher.Block
+	ctr cipher.Block
+}
+
+// newAESSIV creates AEAD_AES_SIV_CMAC_256 from a 32-byte key
+func newAESSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("aes-siv: key must be 32 bytes, got %d", len(key))
+	}
+	mac, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[16:])
+	if err != nil {
+		return nil, err
+	}
+	return &aesSIV{mac: mac, ctr: ctr}, nil
+}
+
+// NonceSize is the nonce size used by NTS, SIV itself accepts any nonce
+func (s *aesSIV) NonceSize() int {
+	return 16
+}
+
+// Overhead is the size of the synthetic IV prepended to the ciphertext
+func (s *aesSIV) Overhead() int {
+	return sivBlockSize
+}
+
+// Seal encrypts and authenticates plaintext, the nonce is the last associated data component (RFC 5297, section 6)
+func (s *aesSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	v := s.s2v(sivComponents(additionalData, nonce), plaintext)
+
+	ret, out := sliceForAppend(dst, sivBlockSize+len(plaintext))
+	copy(out, v[:])
+	s.xorCTR(out[sivBlockSize:], plaintext, v)
+	return ret
+}
+
+// Open decrypts ciphertext and checks that it was produced with the same key, nonce and associated data
+func (s *aesSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < sivBlockSize {
+		return nil, ErrSIVAuthFailed
+	}
+
+	var v [sivBlockSize]byte
+	copy(v[:], ciphertext[:sivBlockSize])
+
+	plaintext := make([]byte, len(ciphertext)-sivBlockSize)
+	s.xorCTR(plaintext, ciphertext[sivBlockSize:], v)
+
+	expected := s.s2v(sivComponents(additionalData, nonce), plaintext)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		return nil, ErrSIVAuthFailed
+	}
+
+	return append(dst, plaintext...), nil
+}
+
+// sivComponents returns the S2V header components, empty nonce is omitted
+func sivComponents(additionalData, nonce []byte) [][]byte {
+	if len(nonce) == 0 {
+		return [][]byte{additionalData}
+	}
+	return [][]byte{additionalData, nonce}
+}
+
+// xorCTR runs AES-CTR keyed by the second half of the key, the counter is V with 2 bits cleared
+func (s *aesSIV) xorCTR(dst, src []byte, v [sivBlockSize]byte) {
+	q := v
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(s.ctr, q[:]).XORKeyStream(dst, src)
+}
+
+// s2v is the "string to vector" pseudo-random function of RFC 5297, section 2.4
+func (s *aesSIV) s2v(components [][]byte, plaintext []byte) [sivBlockSize]byte {
+	var zero [sivBlockSize]byte
+	d := cmac(s.mac, zero[:])
+
+	for _, component := range components {
+		d = dbl(d)
+		c := cmac(s.mac, component)
+		xorBlock(d[:], c[:])
+	}
+
+	var t []byte
+	if len(plaintext) >= sivBlockSize {
+		// xorend: D is xored into the last 16 bytes of the plaintext
+		t = append([]byte(nil), plaintext...)
+		xorBlock(t[len(t)-sivBlockSize:], d[:])
+	} else {
+		d = dbl(d)
+		var padded [sivBlockSize]byte
+		copy(padded[:], plaintext)
+		padded[len(plaintext)] = 0x80
+		xorBlock(padded[:], d[:])
+		t = padded[:]
+	}
+
+	return cmac(s.mac, t)
+}
+
+// cmac computes AES-CMAC (RFC 4493) of message
+func cmac(block cipher.Block, message []byte) [sivBlockSize]byte {
+	var l [sivBlockSize]byte
+	block.Encrypt(l[:], l[:])
+	k1 := dbl(l)
+	k2 := dbl(k1)
+
+	var x [sivBlockSize]byte
+	n := len(message)
+	for n > sivBlockSize {
+		xorBlock(x[:], message[:sivBlockSize])
+		block.Encrypt(x[:], x[:])
+		message = message[sivBlockSize:]
+		n -= sivBlockSize
+	}
+
+	var last [sivBlockSize]byte
+	copy(last[:], message)
+	if n == sivBlockSize {
+		xorBlock(last[:], k1[:])
+	} else {
+		last[n] = 0x80
+		xorBlock(last[:], k2[:])
+	}
+	xorBlock(x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl multiplies a block by x in GF(2^128)
+func dbl(b [sivBlockSize]byte) [sivBlockSize]byte {
+	var out [sivBlockSize]byte
+	carry := b[0] >> 7
+	for i := 0; i < sivBlockSize-1; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[sivBlockSize-1] = b[sivBlockSize-1] << 1
+	out[sivBlockSize-1] ^= 0x87 * carry
+	return out
+}
+
+// xorBlock xors src into dst, both must be at least as long as src
+func xorBlock(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+// sliceForAppend extends in by n bytes and returns the whole slice and the new part, like the stdlib AEADs do
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"l2_8/ntpclock"
+)
+
+// output formats accepted by -format
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// time sources of a report
+const (
+	sourceNTP      = "ntp"
+	sourceHTTPDate = "http-date"
+)
+
+// serverReport is everything we know about one queried server, durations are in seconds
+type serverReport struct {
+	Address string `json:"address"`
+	// Error is set when the server didn't answer at all, other fields are empty then
+	Error string `json:"error,omitempty"`
+	// Status is truechimer or falseticker, empty if the response wasn't usable
+	Status string `json:"status,omitempty"`
+	// LeapStyle is how the server passes leap seconds: step, smear or unknown
+	LeapStyle string `json:"leap_style,omitempty"`
+	// KissCode is set when the server answered with a kiss-o'-death (RATE, DENY, RSTR...)
+	KissCode string `json:"kiss_code,omitempty"`
+
+	Offset         float64 `json:"offset_seconds"`
+	RTT            float64 `json:"rtt_seconds"`
+	Stratum        uint8   `json:"stratum"`
+	ReferenceID    string  `json:"reference_id"`
+	Leap           string  `json:"leap"`
+	RootDelay      float64 `json:"root_delay_seconds"`
+	RootDispersion float64 `json:"root_dispersion_seconds"`
+	RootDistance   float64 `json:"root_distance_seconds"`
+	Precision      float64 `json:"precision_seconds"`
+	// Validate is "ok" or the error returned by ntp.Response.Validate
+	Validate string `json:"validate"`
+	// Auth is the authentication method that verified the response or "none"
+	Auth string `json:"auth"`
+}
+
+// consensusReport is the combined result of selection
+type consensusReport struct {
+	Offset       float64 `json:"offset_seconds"`
+	ErrorBound   float64 `json:"error_bound_seconds"`
+	Truechimers  int     `json:"truechimers"`
+	Falsetickers int     `json:"falsetickers"`
+}
+
+// leapReport tells about a pending leap second and a mix of smearing and stepping servers
+type leapReport struct {
+	// Pending is insert, delete or none
+	Pending string `json:"pending"`
+	// Day is the UTC date which ends with the leap second
+	Day        string   `json:"day,omitempty"`
+	Announcing int      `json:"announcing_servers"`
+	Smearing   []string `json:"smearing_servers,omitempty"`
+	Stepping   []string `json:"stepping_servers,omitempty"`
+	Warning    string   `json:"warning,omitempty"`
+}
+
+// timeReport is the whole output of one run of the tool
+type timeReport struct {
+	// Source is where the time came from: ntp or http-date
+	Source    string           `json:"source"`
+	Servers   []serverReport   `json:"servers"`
+	Consensus *consensusReport `json:"consensus,omitempty"`
+	Leap      leapReport       `json:"leap"`
+	// Time is the corrected local time, empty if there is no consensus
+	Time  string `json:"time,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// buildReport converts samples and the selection result into a report
+func buildReport(samples []ntpclock.Sample, result ntpclock.Consensus, selectionErr error, now time.Time) timeReport {
+	truechimers := make(map[int]struct{}, len(result.Truechimers))
+	for _, i := range result.Truechimers {
+		truechimers[i] = struct{}{}
+	}
+
+	leap := ntpclock.AnalyzeLeap(samples, now)
+	report := timeReport{
+		Servers: make([]serverReport, 0, len(samples)),
+		Leap:    buildLeapReport(samples, leap),
+	}
+	for i, sample := range samples {
+		server := serverReport{Address: sample.Address}
+
+		if sample.Response == nil {
+			server.Error = sample.Err.Error()
+			report.Servers = append(report.Servers, server)
+			continue
+		}
+
+		response := sample.Response
+		if response.IsKissOfDeath() {
+			server.KissCode = response.KissCode
+		}
+		server.Offset = response.ClockOffset.Seconds()
+		server.RTT = response.RTT.Seconds()
+		server.Stratum = response.Stratum
+		server.ReferenceID = response.ReferenceString()
+		server.Leap = leapString(response.Leap)
+		server.RootDelay = response.RootDelay.Seconds()
+		server.RootDispersion = response.RootDispersion.Seconds()
+		server.RootDistance = response.RootDistance.Seconds()
+		server.Precision = response.Precision.Seconds()
+		server.Auth = authString(sample.Auth)
+		if sample.Err == nil {
+			server.LeapStyle = leap.Styles[i].String()
+		}
+
+		// response is present, so Err can only come from Validate
+		server.Validate = "ok"
+		if sample.Err != nil {
+			server.Validate = sample.Err.Error()
+		} else if _, ok := truechimers[i]; ok {
+			server.Status = "truechimer"
+		} else {
+			server.Status = "falseticker"
+		}
+
+		report.Servers = append(report.Servers, server)
+	}
+
+	if selectionErr != nil {
+		report.Error = selectionErr.Error()
+		return report
+	}
+
+	report.Consensus = &consensusReport{
+		Offset:       result.Offset.Seconds(),
+		ErrorBound:   result.ErrorBound.Seconds(),
+		Truechimers:  len(result.Truechimers),
+		Falsetickers: len(result.Falsetickers),
+	}
+	report.Time = now.Add(result.Offset).Format(time.RFC3339Nano)
+	return report
+}
+
+// buildLeapReport describes the leap status of samples, the warning is set when smearing and stepping servers are mixed
+func buildLeapReport(samples []ntpclock.Sample, status ntpclock.LeapStatus) leapReport {
+	report := leapReport{Pending: "none", Announcing: len(status.Announcing)}
+	switch status.Pending {
+	case ntp.LeapAddSecond:
+		report.Pending = "insert"
+	case ntp.LeapDelSecond:
+		report.Pending = "delete"
+	}
+	if !status.At.IsZero() {
+		report.Day = status.At.AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+
+	for _, i := range status.Smearing {
+		report.Smearing = append(report.Smearing, samples[i].Address)
+	}
+	for _, i := range status.Stepping {
+		report.Stepping = append(report.Stepping, samples[i].Address)
+	}
+	if status.Mixed {
+		report.Warning = fmt.Sprintf("smearing (%s) and stepping (%s) servers are mixed, around a leap second their time differs by up to 1s",
+			strings.Join(report.Smearing, ", "), strings.Join(report.Stepping, ", "))
+	}
+	return report
+}
+
+// writeLeap writes the pending leap second and the mixing warning, nothing if there is neither
+func writeLeap(w io.Writer, report leapReport, servers int) {
+	switch report.Pending {
+	case "insert":
+		fmt.Fprintf(w, "leap second pending: 23:59:60 is inserted at the end of %s UTC (announced by %d of %d servers)\n",
+			report.Day, report.Announcing, servers)
+	case "delete":
+		fmt.Fprintf(w, "leap second pending: 23:59:59 is deleted at the end of %s UTC (announced by %d of %d servers)\n",
+			report.Day, report.Announcing, servers)
+	}
+	if report.Warning != "" {
+		fmt.Fprintf(w, "warning: %s\n", report.Warning)
+	}
+}
+
+// leapString returns a human-readable leap indicator
+func leapString(leap ntp.LeapIndicator) string {
+	switch leap {
+	case ntp.LeapNoWarning:
+		return "no warning"
+	case ntp.LeapAddSecond:
+		return "add second"
+	case ntp.LeapDelSecond:
+		return "delete second"
+	case ntp.LeapNotInSync:
+		return "not in sync"
+	default:
+		return fmt.Sprintf("unknown (%d)", leap)
+	}
+}
+
+// sourceString returns a human-readable time source
+func sourceString(source string) string {
+	if source == sourceHTTPDate {
+		return "HTTP Date headers"
+	}
+	return "NTP"
+}
+
+// authString returns the authentication method for output, "none" for unauthenticated responses
+func authString(auth string) string {
+	if auth == "" {
+		return "none"
+	}
+	return auth
+}
+
+// writeJSON writes the report as one indented JSON document
+func writeJSON(w io.Writer, report any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(report)
+}
+
+// writeVerbose writes every field of every server as aligned text
+func writeVerbose(w io.Writer, report timeReport) {
+	for _, server := range report.Servers {
+		fmt.Fprintf(w, "server %s:\n", server.Address)
+		if server.Error != "" {
+			fmt.Fprintf(w, "  error:           %s\n", server.Error)
+			continue
+		}
+		if server.Status != "" {
+			fmt.Fprintf(w, "  status:          %s\n", server.Status)
+		}
+		if server.KissCode != "" {
+			fmt.Fprintf(w, "  kiss code:       %s\n", server.KissCode)
+		}
+		fmt.Fprintf(w, "  clock offset:    %v\n", seconds(server.Offset))
+		fmt.Fprintf(w, "  round-trip:      %v\n", seconds(server.RTT))
+		fmt.Fprintf(w, "  stratum:         %d\n", server.Stratum)
+		fmt.Fprintf(w, "  reference id:    %s\n", server.ReferenceID)
+		fmt.Fprintf(w, "  leap indicator:  %s\n", server.Leap)
+		if server.LeapStyle != "" {
+			fmt.Fprintf(w, "  leap style:      %s\n", server.LeapStyle)
+		}
+		fmt.Fprintf(w, "  root delay:      %v\n", seconds(server.RootDelay))
+		fmt.Fprintf(w, "  root dispersion: %v\n", seconds(server.RootDispersion))
+		fmt.Fprintf(w, "  root distance:   %v\n", seconds(server.RootDistance))
+		fmt.Fprintf(w, "  precision:       %v\n", seconds(server.Precision))
+		fmt.Fprintf(w, "  validate:        %s\n", server.Validate)
+		fmt.Fprintf(w, "  authentication:  %s\n", server.Auth)
+	}
+
+	fmt.Fprintf(w, "time source: %s\n", sourceString(report.Source))
+	if report.Consensus != nil {
+		fmt.Fprintf(w, "consensus offset: %v ± %v (%d of %d servers)\n",
+			seconds(report.Consensus.Offset), seconds(report.Consensus.ErrorBound),
+			report.Consensus.Truechimers, len(report.Servers))
+	}
+	writeLeap(w, report.Leap, len(report.Servers))
+}
+
+// seconds converts float seconds back to time.Duration for printing
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
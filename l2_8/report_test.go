@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"l2_8/ntpclock"
+)
+
+// fakeQuery answers every address with the local clock shifted by offset, the way a healthy server would
+func fakeQuery(offset time.Duration, stratum uint8) ntpclock.QueryFunc {
+	return func(address string) ntpclock.Sample {
+		now := time.Now().Add(offset)
+		response := &ntp.Response{
+			Time:          now,
+			ClockOffset:   offset,
+			RTT:           time.Millisecond,
+			Stratum:       stratum,
+			ReferenceID:   0x7f000001,
+			ReferenceTime: now.Add(-time.Second),
+			RootDistance:  time.Millisecond,
+			Leap:          ntp.LeapNoWarning,
+		}
+		return ntpclock.Sample{Address: address, Response: response, Err: response.Validate()}
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	samples := []ntpclock.Sample{
+		fakeQuery(0, 3)("127.0.0.1:123"),
+		{Address: "127.0.0.1:1", Err: errors.New("connection refused")},
+	}
+
+	result, err := ntpclock.SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = writeJSON(&buf, buildReport(samples, result, nil, time.Now())); err != nil {
+		t.Fatalf("write json: %v", err)
+	}
+
+	var decoded timeReport
+	if err = json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid json: %v\n%s", err, buf.String())
+	}
+
+	if len(decoded.Servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(decoded.Servers))
+	}
+	good := decoded.Servers[0]
+	if good.Stratum != 3 || good.Validate != "ok" || good.Status != "truechimer" || good.Leap != "no warning" {
+		t.Errorf("unexpected server report: %+v", good)
+	}
+	if good.ReferenceID != "127.0.0.1" {
+		t.Errorf("unexpected reference id: %s", good.ReferenceID)
+	}
+	if decoded.Servers[1].Error == "" {
+		t.Errorf("expected error for the second server")
+	}
+	if decoded.Consensus == nil || decoded.Consensus.Truechimers != 1 || decoded.Time == "" {
+		t.Errorf("unexpected consensus: %+v, time %q", decoded.Consensus, decoded.Time)
+	}
+}
+
+func TestReportSource(t *testing.T) {
+	samples := []ntpclock.Sample{{
+		Address:  "http://127.0.0.1:8080",
+		Response: &ntp.Response{ClockOffset: time.Second, RootDistance: 10 * time.Millisecond},
+	}}
+	result, err := ntpclock.SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := buildReport(samples, result, nil, time.Now())
+	report.Source = sourceHTTPDate
+
+	var buf bytes.Buffer
+	writeVerbose(&buf, report)
+	if !strings.Contains(buf.String(), "time source: HTTP Date headers\n") {
+		t.Errorf("verbose output must name the source:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err = writeJSON(&buf, report); err != nil {
+		t.Fatalf("write json: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"source": "http-date"`) {
+		t.Errorf("json output must name the source:\n%s", buf.String())
+	}
+}
+
+func TestReportKissCode(t *testing.T) {
+	samples := []ntpclock.Sample{{
+		Address:  "127.0.0.1:123",
+		Response: &ntp.Response{Stratum: 0, KissCode: "RATE", ClockOffset: -time.Hour},
+		Err:      ntp.ErrKissOfDeath,
+	}}
+	result, err := ntpclock.SelectTruechimers(samples)
+	report := buildReport(samples, result, err, time.Now())
+
+	server := report.Servers[0]
+	if server.KissCode != "RATE" || server.Status != "" {
+		t.Errorf("expected RATE kiss without status, got %+v", server)
+	}
+	if report.Consensus != nil || report.Time != "" {
+		t.Errorf("a kiss must not give a time: %+v", report)
+	}
+}
+
+func TestReportLeap(t *testing.T) {
+	samples := []ntpclock.Sample{
+		{Address: "10.0.0.1:123", Response: &ntp.Response{Stratum: 2, Leap: ntp.LeapAddSecond, RootDistance: 10 * time.Millisecond}},
+		{Address: "time.google.com", Response: &ntp.Response{Stratum: 1, RootDistance: 10 * time.Millisecond}},
+	}
+	result, err := ntpclock.SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, time.June, 30, 20, 0, 0, 0, time.UTC)
+	report := buildReport(samples, result, nil, now)
+
+	leap := report.Leap
+	if leap.Pending != "insert" || leap.Day != "2026-06-30" || leap.Announcing != 1 || leap.Warning == "" {
+		t.Errorf("unexpected leap report: %+v", leap)
+	}
+	if report.Servers[0].LeapStyle != "step" || report.Servers[1].LeapStyle != "smear" {
+		t.Errorf("unexpected leap styles: %s, %s", report.Servers[0].LeapStyle, report.Servers[1].LeapStyle)
+	}
+
+	var buf bytes.Buffer
+	writeLeap(&buf, leap, len(report.Servers))
+	expected := "leap second pending: 23:59:60 is inserted at the end of 2026-06-30 UTC (announced by 1 of 2 servers)\n" +
+		"warning: smearing (time.google.com) and stepping (10.0.0.1:123) servers are mixed, around a leap second their time differs by up to 1s\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected leap output:\n%s", buf.String())
+	}
+}
+
+func TestReportLeapUnknownStyle(t *testing.T) {
+	samples := []ntpclock.Sample{
+		{Address: "192.0.2.1:123", Response: &ntp.Response{Stratum: 2, RootDistance: 10 * time.Millisecond}},
+		{Address: "time.google.com", Response: &ntp.Response{Stratum: 1, RootDistance: 10 * time.Millisecond}},
+	}
+	result, err := ntpclock.SelectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := buildReport(samples, result, nil, time.Now())
+
+	if report.Leap.Warning != "" {
+		t.Errorf("no leap is pending and only one server is known to smear, got warning: %s", report.Leap.Warning)
+	}
+	if report.Servers[0].LeapStyle != "unknown" || report.Servers[1].LeapStyle != "smear" {
+		t.Errorf("unexpected leap styles: %s, %s", report.Servers[0].LeapStyle, report.Servers[1].LeapStyle)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"l2_8/ntpclock"
+)
+
+// roughtimeServerReport is the verified answer of one Roughtime server, durations are in seconds
+type roughtimeServerReport struct {
+	Address string `json:"address"`
+	// Error is set when the server didn't answer or its reply failed verification
+	Error    string  `json:"error,omitempty"`
+	Midpoint string  `json:"midpoint,omitempty"`
+	Radius   float64 `json:"radius_seconds"`
+	Offset   float64 `json:"offset_seconds"`
+	RTT      float64 `json:"rtt_seconds"`
+}
+
+// roughtimeReport is the output of the -roughtime mode
+type roughtimeReport struct {
+	Servers []roughtimeServerReport `json:"servers"`
+	// Consistent is false when the chain proves that one of the servers lies
+	Consistent bool `json:"consistent"`
+	// Error is the chain inconsistency or why no server could be verified
+	Error string `json:"error,omitempty"`
+}
+
+// parseRoughtimeServers parses -roughtime values
+func parseRoughtimeServers(values []string) ([]ntpclock.RoughtimeServer, error) {
+	servers := make([]ntpclock.RoughtimeServer, 0, len(values))
+	for _, value := range values {
+		server, err := ntpclock.ParseRoughtimeServer(value)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// buildRoughtimeReport converts results of a chain, chainErr is what QueryRoughtimeChain returned
+func buildRoughtimeReport(results []ntpclock.RoughtimeResult, chainErr error) roughtimeReport {
+	report := roughtimeReport{
+		Servers:    make([]roughtimeServerReport, 0, len(results)),
+		Consistent: !errors.Is(chainErr, ntpclock.ErrRoughtimeInconsistent),
+	}
+
+	verified := 0
+	for _, result := range results {
+		server := roughtimeServerReport{Address: result.Server.Address}
+		if result.Err != nil {
+			server.Error = result.Err.Error()
+			report.Servers = append(report.Servers, server)
+			continue
+		}
+		verified++
+		server.Midpoint = result.Midpoint.UTC().Format(time.RFC3339Nano)
+		server.Radius = result.Radius.Seconds()
+		server.Offset = result.Offset.Seconds()
+		server.RTT = result.RTT.Seconds()
+		report.Servers = append(report.Servers, server)
+	}
+
+	switch {
+	case chainErr != nil:
+		report.Error = chainErr.Error()
+	case verified == 0:
+		report.Error = "no roughtime server answered with a verified time"
+	}
+	return report
+}
+
+// writeRoughtime prints one line per server with its midpoint and radius, then the chain verdict
+func writeRoughtime(w io.Writer, report roughtimeReport) {
+	for _, server := range report.Servers {
+		if server.Error != "" {
+			fmt.Fprintf(w, "  %s: error: %s\n", server.Address, server.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: midpoint %s ± %v, offset %v, rtt %v\n",
+			server.Address, server.Midpoint, seconds(server.Radius), seconds(server.Offset), seconds(server.RTT))
+	}
+	if !report.Consistent {
+		fmt.Fprintln(w, "WARNING: the chain proves that one of the servers lies")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"l2_8/ntpclock"
+)
+
+func TestRoughtimeReport(t *testing.T) {
+	midpoint := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	results := []ntpclock.RoughtimeResult{
+		{
+			Server:   ntpclock.RoughtimeServer{Address: "a:2002"},
+			Midpoint: midpoint,
+			Radius:   time.Second,
+			Offset:   250 * time.Millisecond,
+			RTT:      20 * time.Millisecond,
+		},
+		{
+			Server: ntpclock.RoughtimeServer{Address: "b:2002"},
+			Err:    fmt.Errorf("%w: bad response signature", ntpclock.ErrRoughtimeVerify),
+		},
+	}
+
+	report := buildRoughtimeReport(results, nil)
+	if !report.Consistent || report.Error != "" {
+		t.Fatalf("unexpected verdict: %+v", report)
+	}
+	if report.Servers[0].Midpoint != "2026-03-01T12:00:00Z" || report.Servers[0].Radius != 1 {
+		t.Errorf("unexpected first server: %+v", report.Servers[0])
+	}
+	if report.Servers[1].Error == "" {
+		t.Error("second server should have an error")
+	}
+
+	var buf bytes.Buffer
+	writeRoughtime(&buf, report)
+	if !strings.Contains(buf.String(), "a:2002: midpoint 2026-03-01T12:00:00Z ± 1s, offset 250ms") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	chainErr := fmt.Errorf("%w: b answered earlier", ntpclock.ErrRoughtimeInconsistent)
+	report = buildRoughtimeReport(results, chainErr)
+	if report.Consistent || report.Error == "" {
+		t.Errorf("inconsistent chain should be reported: %+v", report)
+	}
+
+	report = buildRoughtimeReport(results[1:], nil)
+	if report.Error == "" {
+		t.Error("a chain without verified answers should be an error")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"l2_8/ntpclock"
+)
+
+// csvHeader is the first line of the -watch output
+var csvHeader = []string{
+	"time", "offset_seconds", "error_bound_seconds", "truechimers", "servers",
+	"drift_ppm", "jitter_seconds", "error",
+}
+
+// watcher polls NTP servers every interval and keeps drift statistics
+//
+// every poll is written as a CSV row to csvOut (if set),
+// the latest state is also served as Prometheus text exposition by ServeHTTP
+type watcher struct {
+	addresses []string
+	query     ntpclock.QueryFunc
+	interval  time.Duration
+	csvOut    *csv.Writer
+
+	// mu guards everything below, ServeHTTP reads it from other goroutines
+	mu         sync.Mutex
+	start      time.Time
+	estimator  *driftEstimator
+	samples    []ntpclock.Sample
+	last       ntpclock.Consensus
+	lastErr    error
+	lastPoll   time.Time
+	polls      uint64
+	pollErrors uint64
+}
+
+// newWatcher creates a watcher, csvOut may be nil to disable CSV streaming
+func newWatcher(addresses []string, query ntpclock.QueryFunc, interval time.Duration, csvOut io.Writer) *watcher {
+	w := &watcher{
+		addresses: addresses,
+		query:     query,
+		interval:  interval,
+		estimator: newDriftEstimator(),
+	}
+	if csvOut != nil {
+		w.csvOut = csv.NewWriter(csvOut)
+	}
+	return w
+}
+
+// run polls until ctx is done, the first poll happens immediately
+func (w *watcher) run(ctx context.Context) error {
+	w.mu.Lock()
+	w.start = time.Now()
+	w.mu.Unlock()
+
+	if w.csvOut != nil {
+		if err := w.writeRow(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll makes one round of queries, updates statistics and writes a CSV row
+//
+// only CSV write errors are returned, query errors are a normal part of watching
+func (w *watcher) poll() error {
+	samples := ntpclock.QueryAll(w.addresses, w.query)
+	result, err := ntpclock.SelectTruechimers(samples)
+	now := time.Now()
+
+	w.mu.Lock()
+	w.samples = samples
+	w.last = result
+	w.lastErr = err
+	w.lastPoll = now
+	w.polls++
+	if err != nil {
+		w.pollErrors++
+	} else {
+		w.estimator.add(now.Sub(w.start), result.Offset)
+	}
+	drift, driftOk := w.estimator.driftPPM()
+	jitter := w.estimator.jitter()
+	w.mu.Unlock()
+
+	if w.csvOut == nil {
+		return nil
+	}
+
+	row := []string{now.UTC().Format(time.RFC3339Nano), "", "", "", strconv.Itoa(len(samples)), "", "", ""}
+	if err != nil {
+		row[7] = err.Error()
+	} else {
+		row[1] = formatFloat(result.Offset.Seconds())
+		row[2] = formatFloat(result.ErrorBound.Seconds())
+		row[3] = strconv.Itoa(len(result.Truechimers))
+	}
+	if driftOk {
+		row[5] = formatFloat(drift)
+	}
+	row[6] = formatFloat(jitter.Seconds())
+
+	return w.writeRow(row)
+}
+
+// writeRow writes and flushes one CSV row, so the output can be tailed
+func (w *watcher) writeRow(row []string) error {
+	if err := w.csvOut.Write(row); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	w.csvOut.Flush()
+	return w.csvOut.Error()
+}
+
+// ServeHTTP writes the latest state in Prometheus text exposition format
+func (w *watcher) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(rw, "ntp_polls_total", "counter", "Number of polls made.", formatFloat(float64(w.polls)))
+	writeMetric(rw, "ntp_poll_errors_total", "counter", "Number of polls without consensus.", formatFloat(float64(w.pollErrors)))
+	if w.polls == 0 {
+		return
+	}
+	writeMetric(rw, "ntp_last_poll_timestamp_seconds", "gauge", "Unix time of the last poll.",
+		formatFloat(float64(w.lastPoll.UnixNano())/1e9))
+
+	if w.lastErr == nil {
+		writeMetric(rw, "ntp_offset_seconds", "gauge", "Consensus clock offset, server minus local.",
+			formatFloat(w.last.Offset.Seconds()))
+		writeMetric(rw, "ntp_error_bound_seconds", "gauge", "Half-width of the consensus interval.",
+			formatFloat(w.last.ErrorBound.Seconds()))
+		writeMetric(rw, "ntp_truechimers", "gauge", "Servers that survived selection.",
+			strconv.Itoa(len(w.last.Truechimers)))
+	}
+
+	if drift, ok := w.estimator.driftPPM(); ok {
+		writeMetric(rw, "ntp_drift_ppm", "gauge", "Estimated local clock frequency error, positive is fast.",
+			formatFloat(drift))
+	}
+	writeMetric(rw, "ntp_jitter_seconds", "gauge", "RMS of consecutive consensus offset differences.",
+		formatFloat(w.estimator.jitter().Seconds()))
+
+	fmt.Fprintln(rw, "# HELP ntp_server_offset_seconds Clock offset of every server that answered.")
+	fmt.Fprintln(rw, "# TYPE ntp_server_offset_seconds gauge")
+	for _, sample := range w.samples {
+		// invalid responses and kisses carry no usable offset
+		if sample.Response == nil || sample.Err != nil {
+			continue
+		}
+		fmt.Fprintf(rw, "ntp_server_offset_seconds{server=%q} %s\n",
+			sample.Address, formatFloat(sample.Response.ClockOffset.Seconds()))
+	}
+
+	fmt.Fprintln(rw, "# HELP ntp_server_kiss Servers that answered the last poll with a kiss-o'-death.")
+	fmt.Fprintln(rw, "# TYPE ntp_server_kiss gauge")
+	for _, sample := range w.samples {
+		if sample.Response == nil || !sample.Response.IsKissOfDeath() {
+			continue
+		}
+		fmt.Fprintf(rw, "ntp_server_kiss{server=%q,code=%q} 1\n", sample.Address, sample.Response.KissCode)
+	}
+}
+
+// writeMetric writes a single unlabelled metric with its HELP and TYPE lines
+func writeMetric(w io.Writer, name, kind, help, value string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, value)
+}
+
+// formatFloat formats a float the shortest way that parses back
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDriftEstimator(t *testing.T) {
+	estimator := newDriftEstimator()
+	if _, ok := estimator.driftPPM(); ok {
+		t.Fatal("drift must be unknown without samples")
+	}
+
+	// local clock gains 50µs every second: the offset (server - local) decreases by 50µs/s = +50 ppm
+	for i := 0; i < 100; i++ {
+		elapsed := time.Duration(i) * time.Second
+		estimator.add(elapsed, time.Duration(-50*i)*time.Microsecond)
+	}
+
+	drift, ok := estimator.driftPPM()
+	if !ok {
+		t.Fatal("expected drift to be known")
+	}
+	if math.Abs(drift-50) > 0.001 {
+		t.Errorf("expected 50 ppm, got %v", drift)
+	}
+	if jitter := estimator.jitter(); jitter != 50*time.Microsecond {
+		t.Errorf("expected 50µs jitter, got %v", jitter)
+	}
+}
+
+func TestWatcherStreamsCSVAndMetrics(t *testing.T) {
+	var out bytes.Buffer
+	w := newWatcher([]string{"127.0.0.1:123"}, fakeQuery(20*time.Millisecond, 2), 5*time.Millisecond, &out)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+	if err := w.run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid csv: %v", err)
+	}
+	if len(rows) < 3 {
+		t.Fatalf("expected header and at least 2 samples, got %d rows", len(rows))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("unexpected header: %v", rows[0])
+	}
+	for _, row := range rows[1:] {
+		if row[7] != "" {
+			t.Errorf("unexpected poll error: %s", row[7])
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	w.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, metric := range []string{"ntp_polls_total ", "ntp_offset_seconds ", "ntp_drift_ppm ", "ntp_server_offset_seconds{server="} {
+		if !strings.Contains(body, metric) {
+			t.Errorf("metric %q is missing in:\n%s", metric, body)
+		}
+	}
+}
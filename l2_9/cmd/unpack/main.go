@@ -0,0 +1,177 @@
+// Command unpack unpacks or packs every line of files or stdin in the format of l2_9
+//
+// Usage:
+//
+//	unpack [flags] [file ...]
+//
+// Without files stdin is read, "-" is stdin as well. Invalid lines are reported to stderr
+// as file:line with a caret diagnostic and the rest of the input is still processed.
+// The exit status is 0 when all lines are valid, 1 when some are not and 2 on usage or I/O errors.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"l2_9"
+)
+
+// exit statuses
+const (
+	exitOK      = 0
+	exitInvalid = 1
+	exitError   = 2
+)
+
+// stdinName is the file name of stdin in arguments and error messages
+const stdinName = "-"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// config is what the flags ask for
+type config struct {
+	pack    bool
+	check   bool
+	options l2_9.Options
+}
+
+// run is main with its environment passed in, it returns the exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("unpack", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	packFlag := flags.Bool("pack", false, "pack lines instead of unpacking them")
+	checkFlag := flags.Bool("check", false, "only validate lines, print nothing but errors")
+	shortestFlag := flags.Bool("shortest", false, "in -pack mode write a run as repeated runes when it takes no more bytes")
+	escapeFlag := flags.String("escape", `\`, "escape rune of the dialect")
+	beforeFlag := flags.Bool("count-before", false, "repeat numbers are written before the rune or group: 4a")
+	maxRepeatFlag := flags.Int64("max-repeat", 0, "largest allowed repeat number, 0 for no limit")
+	rejectZeroFlag := flags.Bool("reject-zero", false, "treat a zero repeat number as an error")
+	graphemesFlag := flags.Bool("graphemes", false, "repeat whole grapheme clusters instead of runes")
+	maxOutputFlag := flags.Int64("max-output", 0, "limit of an unpacked line in bytes, 0 for no limit")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: unpack [flags] [file ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitError
+	}
+
+	escape := []rune(*escapeFlag)
+	if len(escape) != 1 {
+		fmt.Fprintf(stderr, "unpack: -escape must be one rune, got %q\n", *escapeFlag)
+		return exitError
+	}
+	cfg := config{
+		pack:  *packFlag,
+		check: *checkFlag,
+		options: l2_9.Options{
+			Escape:     escape[0],
+			MaxRepeat:  *maxRepeatFlag,
+			RejectZero: *rejectZeroFlag,
+			Graphemes:  *graphemesFlag,
+			MaxOutput:  *maxOutputFlag,
+			Shortest:   *shortestFlag,
+		},
+	}
+	if *beforeFlag {
+		cfg.options.CountPosition = l2_9.CountBefore
+	}
+	// an empty string is valid in every dialect, so only bad options fail here
+	if _, err := l2_9.UnpackWith("", cfg.options); err != nil {
+		fmt.Fprintf(stderr, "unpack: %v\n", err)
+		return exitError
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{stdinName}
+	}
+
+	out := bufio.NewWriter(stdout)
+	status := exitOK
+	for _, name := range files {
+		status = max(status, processFile(cfg, name, stdin, out, stderr))
+	}
+	if err := out.Flush(); err != nil {
+		fmt.Fprintf(stderr, "unpack: failed to write output: %v\n", err)
+		return exitError
+	}
+	return status
+}
+
+// processFile opens the named file or takes stdin and processes its lines
+func processFile(cfg config, name string, stdin io.Reader, out *bufio.Writer, stderr io.Writer) int {
+	if name == stdinName {
+		return processLines(cfg, "stdin", stdin, out, stderr)
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintf(stderr, "unpack: %v\n", err)
+		return exitError
+	}
+	defer file.Close()
+	return processLines(cfg, name, file, out, stderr)
+}
+
+// processLines unpacks or packs every line of in, an invalid line is reported and skipped
+func processLines(cfg config, name string, in io.Reader, out *bufio.Writer, stderr io.Writer) int {
+	reader := bufio.NewReader(in)
+	status := exitOK
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			fmt.Fprintf(stderr, "unpack: %s: %v\n", name, err)
+			return exitError
+		}
+		if line == "" && err != nil {
+			return status
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if !cfg.pack {
+			// CRLF input is fine to unpack, but a packed line keeps its \r to unpack back to the same bytes
+			line = strings.TrimSuffix(line, "\r")
+		}
+		result, convertErr := convert(cfg, line)
+		if convertErr != nil {
+			reportError(stderr, name, number, line, convertErr)
+			status = exitInvalid
+		} else if !cfg.check {
+			out.WriteString(result)
+			out.WriteByte('\n')
+		}
+
+		if err != nil {
+			return status
+		}
+	}
+}
+
+// convert unpacks or packs one line
+func convert(cfg config, line string) (string, error) {
+	if cfg.pack {
+		return l2_9.PackWith(line, cfg.options)
+	}
+	return l2_9.UnpackWith(line, cfg.options)
+}
+
+// reportError writes file:line: error and, when the error has a position, the caret diagnostic
+func reportError(stderr io.Writer, name string, number int, line string, err error) {
+	fmt.Fprintf(stderr, "%s:%d: %v\n", name, number, err)
+
+	var unpackErr *l2_9.UnpackError
+	if errors.As(err, &unpackErr) {
+		fmt.Fprintln(stderr, unpackErr.Diagnostic(line))
+	}
+}
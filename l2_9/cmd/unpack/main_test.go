@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"l2_9"
+)
+
+// runCLI runs the command and returns its exit status, stdout and stderr
+func runCLI(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestRunUnpack(t *testing.T) {
+	status, stdout, stderr := runCLI("a4bc2d5e\n\nqwe\\45\r\n(ab)2")
+	if status != exitOK || stdout != "aaaabccddddde\n\nqwe44444\nabab\n" || stderr != "" {
+		t.Errorf("unexpected result: %d, %q, %q", status, stdout, stderr)
+	}
+}
+
+func TestRunPack(t *testing.T) {
+	status, stdout, _ := runCLI("aaaabccddddde\nqwe44444\n", "-pack")
+	if status != exitOK || stdout != "a4bc2d5e\nqwe\\45\n" {
+		t.Errorf("unexpected result: %d, %q", status, stdout)
+	}
+
+	status, stdout, _ = runCLI("4a2(bc)\n", "-count-before", "-escape", "/")
+	if status != exitOK || stdout != "aaaabcbc\n" {
+		t.Errorf("unexpected dialect result: %d, %q", status, stdout)
+	}
+}
+
+func TestRunPackKeepsCarriageReturn(t *testing.T) {
+	status, stdout, _ := runCLI("ab\r\r\r\n", "-pack")
+	if status != exitOK || stdout != "ab\r3\n" {
+		t.Fatalf("unexpected result: %d, %q", status, stdout)
+	}
+
+	unpacked, err := l2_9.Unpack(strings.TrimSuffix(stdout, "\n"))
+	if err != nil || unpacked != "ab\r\r\r" {
+		t.Errorf("packed line must unpack back to the input: %q, %v", unpacked, err)
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	status, stdout, stderr := runCLI("a2\n45\nb\\\n", "-check")
+	if status != exitInvalid || stdout != "" {
+		t.Errorf("unexpected result: %d, %q", status, stdout)
+	}
+
+	expected := []string{
+		"stdin:2: invalid unpack string",
+		"45\n^ ",
+		"stdin:3: invalid unpack string",
+		"b\\\n ^ ",
+	}
+	for _, part := range expected {
+		if !strings.Contains(stderr, part) {
+			t.Errorf("stderr %q doesn't contain %q", stderr, part)
+		}
+	}
+	if strings.Contains(stderr, "stdin:1:") {
+		t.Errorf("valid line reported: %q", stderr)
+	}
+
+	// valid lines are still printed without -check
+	status, stdout, _ = runCLI("a2\n45\nb\n")
+	if status != exitInvalid || stdout != "aa\nb\n" {
+		t.Errorf("unexpected result: %d, %q", status, stdout)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	if err := os.WriteFile(first, []byte("a3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("b2\nc0d"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, stdout, _ := runCLI("x2\n", first, "-", second)
+	if status != exitOK || stdout != "aaa\nxx\nbb\nd\n" {
+		t.Errorf("unexpected result: %d, %q", status, stdout)
+	}
+
+	status, _, stderr := runCLI("", "-reject-zero", second)
+	if status != exitInvalid || !strings.Contains(stderr, second+":2: ") {
+		t.Errorf("unexpected result: %d, %q", status, stderr)
+	}
+
+	status, _, stderr = runCLI("", filepath.Join(dir, "missing.txt"))
+	if status != exitError || stderr == "" {
+		t.Errorf("unexpected result for a missing file: %d, %q", status, stderr)
+	}
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	for _, args := range [][]string{{"-escape", "ab"}, {"-escape", "1"}, {"-max-repeat", "-1"}, {"-unknown"}} {
+		if status, _, _ := runCLI("", args...); status != exitError {
+			t.Errorf("%v: expected exit status %d, got %d", args, exitError, status)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package l2_9
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorruptData is an error that describes a case when binary encoded data can't be decoded
+var ErrCorruptData = errors.New("corrupt encoded data")
+
+// Codec is a run-length encoding, every Decode is the inverse of Encode
+//
+// the stream methods return the number of bytes written to dst, on error dst may already have a part of the output
+type Codec interface {
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+	EncodeStream(dst io.Writer, src io.Reader) (int64, error)
+	DecodeStream(dst io.Writer, src io.Reader) (int64, error)
+}
+
+var (
+	_ Codec = TextCodec{}
+	_ Codec = ByteRLE{}
+	_ Codec = PackBits{}
+)
+
+// TextCodec is the human-readable format of Pack and Unpack in the dialect of Options
+type TextCodec struct {
+	Options Options
+}
+
+// Encode packs src, it must be valid UTF-8
+func (c TextCodec) Encode(src []byte) ([]byte, error) {
+	packed, err := PackWith(string(src), c.Options)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(packed), nil
+}
+
+// Decode unpacks src
+func (c TextCodec) Decode(src []byte) ([]byte, error) {
+	return decodeBytes(c, src)
+}
+
+// EncodeStream packs src, runs may span the whole input so it's read into memory first
+func (c TextCodec) EncodeStream(dst io.Writer, src io.Reader) (int64, error) {
+	input, err := io.ReadAll(src)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to read input: %w", ErrInternalUnpackingError, err)
+	}
+	packed, err := c.Encode(input)
+	if err != nil {
+		return 0, err
+	}
+	written, err := dst.Write(packed)
+	return int64(written), err
+}
+
+// DecodeStream unpacks src as it goes, see UnpackStreamWith
+func (c TextCodec) DecodeStream(dst io.Writer, src io.Reader) (int64, error) {
+	return UnpackStreamWith(dst, src, c.Options)
+}
+
+// encodeBytes runs EncodeStream of codec over a byte slice
+func encodeBytes(codec Codec, src []byte) ([]byte, error) {
+	result := bytes.Buffer{}
+	if _, err := codec.EncodeStream(&result, bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// decodeBytes runs DecodeStream of codec over a byte slice
+func decodeBytes(codec Codec, src []byte) ([]byte, error) {
+	result := bytes.Buffer{}
+	if _, err := codec.DecodeStream(&result, bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// byteWriter counts written bytes and keeps the output limit of binary codecs
+type byteWriter struct {
+	out       io.Writer
+	maxOutput int64
+	written   int64
+}
+
+// writeRepeated writes b count times, index is the input offset reported by OutputLimitError
+func (w *byteWriter) writeRepeated(b byte, count int, index int) error {
+	return w.write(bytes.Repeat([]byte{b}, count), index)
+}
+
+// write writes p or fails with OutputLimitError before writing anything of it
+func (w *byteWriter) write(p []byte, index int) error {
+	if w.maxOutput > 0 && int64(len(p)) > w.maxOutput-w.written {
+		return &OutputLimitError{Limit: w.maxOutput, Index: index}
+	}
+	written, err := w.out.Write(p)
+	w.written += int64(written)
+	if err != nil {
+		return fmt.Errorf("%w: failed to write output: %w", ErrInternalUnpackingError, err)
+	}
+	return nil
+}
+
+// readError converts an error of reading encoded data: a cut off input is corrupt
+func readError(err error, what string) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: input ends in the middle of %s", ErrCorruptData, what)
+	}
+	return fmt.Errorf("%w: failed to read input: %w", ErrInternalUnpackingError, err)
+}
@@ -0,0 +1,153 @@
+package l2_9
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnpackErrorReason is a code of what's wrong with an unpack string
+type UnpackErrorReason int
+
+const (
+	// ReasonRepeatWithoutSymbol is a repeat number that has no rune or group before it: "45", "3a", "(2)"
+	ReasonRepeatWithoutSymbol UnpackErrorReason = iota + 1
+	// ReasonRepeatTooLarge is a repeat number that doesn't fit in int64
+	ReasonRepeatTooLarge
+	// ReasonTrailingEscape is an escape rune (Options.Escape, '\' by default) at the very end with nothing to escape
+	ReasonTrailingEscape
+	// ReasonUnclosedGroup is a '(' without its ')'
+	ReasonUnclosedGroup
+	// ReasonUnbalancedGroup is a ')' without its '('
+	ReasonUnbalancedGroup
+	// ReasonGroupTooDeep is a '(' nested deeper than MaxGroupDepth
+	ReasonGroupTooDeep
+	// ReasonDanglingRepeat is a repeat number with nothing after it in CountBefore dialects: "a3", "(a3)"
+	ReasonDanglingRepeat
+	// ReasonZeroRepeat is a zero repeat number when Options.RejectZero is set
+	ReasonZeroRepeat
+)
+
+// String returns the reason as it's written in error messages
+func (r UnpackErrorReason) String() string {
+	switch r {
+	case ReasonRepeatWithoutSymbol:
+		return "repeat number must follow the repeated rune"
+	case ReasonRepeatTooLarge:
+		return "repeat number is too large"
+	case ReasonTrailingEscape:
+		return "mustn't end with escaping"
+	case ReasonUnclosedGroup:
+		return "group is not closed"
+	case ReasonUnbalancedGroup:
+		return "group is closed but not opened"
+	case ReasonGroupTooDeep:
+		return "groups are nested too deep"
+	case ReasonDanglingRepeat:
+		return "repeat number must precede the repeated rune"
+	case ReasonZeroRepeat:
+		return "repeat number mustn't be zero"
+	default:
+		return "unknown reason"
+	}
+}
+
+// expected returns what should have been at the error position with the default Options,
+// the unpacker replaces the texts that depend on the options in use
+func (r UnpackErrorReason) expected() string {
+	switch r {
+	case ReasonRepeatWithoutSymbol:
+		return "a rune or an escape before the repeat number"
+	case ReasonRepeatTooLarge:
+		return "a shorter repeat number"
+	case ReasonTrailingEscape:
+		return "a rune after the escape"
+	case ReasonUnclosedGroup:
+		return "')' for this '('"
+	case ReasonUnbalancedGroup:
+		return "'(' before it or an escaped '\\)'"
+	case ReasonGroupTooDeep:
+		return fmt.Sprintf("at most %d nested groups", MaxGroupDepth)
+	case ReasonDanglingRepeat:
+		return "a rune or a group after the repeat number"
+	case ReasonZeroRepeat:
+		return "a repeat number of at least 1"
+	default:
+		return ""
+	}
+}
+
+// UnpackError describes where and why an unpack string is invalid, errors.Is(err, ErrInvalidUnpackString)
+// is true for it
+//
+// Example:
+//
+//	var unpackErr *UnpackError
+//	if errors.As(err, &unpackErr) {
+//		fmt.Println(unpackErr.Diagnostic(input))
+//	}
+type UnpackError struct {
+	// Index is the rune index of the offending rune
+	Index int
+	// Offset is the byte offset of the offending rune
+	Offset int64
+	// Rune is the offending rune
+	Rune rune
+	// Reason is what's wrong
+	Reason UnpackErrorReason
+	// Expected describes what should have been at this position
+	Expected string
+}
+
+// newUnpackError creates an UnpackError with Expected filled by the reason
+func newUnpackError(reason UnpackErrorReason, index int, offset int64, r rune) *UnpackError {
+	return &UnpackError{Index: index, Offset: offset, Rune: r, Reason: reason, Expected: reason.expected()}
+}
+
+// Error implements error
+func (e *UnpackError) Error() string {
+	return fmt.Sprintf("%v: %v, index %d", ErrInvalidUnpackString, e.Reason, e.Index)
+}
+
+// Unwrap lets errors.Is match ErrInvalidUnpackString
+func (e *UnpackError) Unwrap() error {
+	return ErrInvalidUnpackString
+}
+
+// Diagnostic renders the input with a caret under the offending rune
+//
+// Example:
+//
+//	a4\
+//	  ^ mustn't end with escaping: expected a rune after the escape
+//
+// input must be the whole string or stream that was unpacked, Index counts runes from its start,
+// of a multi-line input only the line with the offending rune is shown;
+// tabs are kept so the caret stays aligned in a terminal
+func (e *UnpackError) Diagnostic(input string) string {
+	line := input
+	padding := strings.Builder{}
+	index := 0
+	for offset, r := range input {
+		if index == e.Index {
+			break
+		}
+		index++
+
+		switch r {
+		case '\n':
+			line = input[offset+1:]
+			padding.Reset()
+		case '\t':
+			padding.WriteRune('\t')
+		default:
+			padding.WriteRune(' ')
+		}
+	}
+	line, _, _ = strings.Cut(line, "\n")
+
+	message := e.Reason.String()
+	if e.Expected != "" {
+		message += ": expected " + e.Expected
+	}
+	return fmt.Sprintf("%s\n%s^ %s", line, padding.String(), message)
+}
@@ -1,3 +1,5 @@
 module l2_9
 
-go 1.25.0
\ No newline at end of file
+go 1.25.0
+
+require github.com/rivo/uniseg v0.4.7
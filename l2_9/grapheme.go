@@ -0,0 +1,63 @@
+package l2_9
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// maxClusterBytes caps a grapheme cluster symbol: real clusters are far shorter (UAX #15 stream-safe text
+// has at most 30 combining marks in a row), and the cap keeps checking boundaries of crafted input linear
+const maxClusterBytes = 128
+
+// continuesCluster reports whether r extends the grapheme cluster of symbol
+//
+// format runes (digits, the escape and parentheses) never do: "é" + "3" repeats "é",
+// but "3⃣" is the repeat number 3 and a lone keycap mark
+func continuesCluster(symbol []byte, r rune, escape rune) bool {
+	if isSyntax(r, escape) || len(symbol)+utf8.RuneLen(r) > maxClusterBytes {
+		return false
+	}
+	_, rest, _, _ := uniseg.FirstGraphemeCluster(utf8.AppendRune(symbol[:len(symbol):len(symbol)], r), -1)
+	return len(rest) == 0
+}
+
+// isSyntax reports whether r is a part of the format and has to be escaped to be a symbol
+func isSyntax(r rune, escape rune) bool {
+	return isDigit(r) || r == escape || r == '(' || r == ')'
+}
+
+// splitUnits splits input into the units Pack repeats: runes or, with graphemes, extended grapheme clusters
+func splitUnits(input string, graphemes bool) []string {
+	var units []string
+	if !graphemes {
+		for _, r := range input {
+			units = append(units, string(r))
+		}
+		return units
+	}
+
+	state := -1
+	for len(input) > 0 {
+		var cluster string
+		cluster, input, _, state = uniseg.FirstGraphemeClusterInString(input, state)
+		units = append(units, cluster)
+	}
+	return units
+}
+
+// encodeUnit escapes a unit for Unpack, single is false when Unpack would read it as several symbols,
+// so a repeat number needs the unit in a group
+func encodeUnit(unit string, escape rune) (encoded string, single bool) {
+	result := strings.Builder{}
+	single = true
+	for i, r := range unit {
+		// single turns false past maxClusterBytes, so long crafted clusters are not checked over and over
+		if single && i > 0 && !continuesCluster([]byte(unit[:i]), r, escape) {
+			single = false
+		}
+		result.WriteString(packSymbol(r, escape))
+	}
+	return result.String(), single
+}
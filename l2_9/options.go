@@ -0,0 +1,80 @@
+package l2_9
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidOptions is an error that describes a case when Options can't describe a working format
+var ErrInvalidOptions = errors.New("invalid options")
+
+// CountPosition is where the repeat number is written relative to the repeated rune or group
+type CountPosition int
+
+const (
+	// CountAfter is "a4", "(ab)3": the default
+	CountAfter CountPosition = iota
+	// CountBefore is "4a", "3(ab)"
+	CountBefore
+)
+
+// Options describe a dialect of the format, the zero value is the default one used by Unpack and Pack
+type Options struct {
+	// Escape makes the next rune literal, 0 means '\'
+	Escape rune
+	// CountPosition is where the repeat number is
+	CountPosition CountPosition
+	// MaxRepeat is the largest allowed repeat number, 0 means no limit.
+	// Pack splits longer runs instead: "a5a2" for 7 'a' with MaxRepeat 5
+	MaxRepeat int64
+	// RejectZero makes a zero repeat number an error instead of dropping the rune
+	RejectZero bool
+	// MaxGroupDepth is how deep groups may be nested, 0 means MaxGroupDepth
+	MaxGroupDepth int
+	// Graphemes makes a symbol a whole extended grapheme cluster (UAX #29) instead of a rune:
+	// "é3" with é written as e and a combining accent repeats both runes
+	Graphemes bool
+	// MaxOutput limits the unpacked output in bytes, 0 means no limit (see UnpackStream)
+	MaxOutput int64
+	// Shortest makes Pack write a run as repeated runes when it takes no more bytes (see PackShortest)
+	Shortest bool
+}
+
+// withDefaults fills zero fields with defaults and checks that the dialect is unambiguous
+func (o Options) withDefaults() (Options, error) {
+	if o.Escape == 0 {
+		o.Escape = '\\'
+	}
+	if o.MaxGroupDepth == 0 {
+		o.MaxGroupDepth = MaxGroupDepth
+	}
+
+	switch {
+	case isDigit(o.Escape) || o.Escape == '(' || o.Escape == ')':
+		return o, fmt.Errorf("%w: escape %q is a part of the format", ErrInvalidOptions, o.Escape)
+	case o.CountPosition != CountAfter && o.CountPosition != CountBefore:
+		return o, fmt.Errorf("%w: unknown count position %d", ErrInvalidOptions, o.CountPosition)
+	case o.MaxRepeat < 0 || o.MaxGroupDepth < 0:
+		return o, fmt.Errorf("%w: limits mustn't be negative", ErrInvalidOptions)
+	}
+	return o, nil
+}
+
+// UnpackWith is Unpack in the dialect of options
+//
+// Example with Options{Escape: '/', CountPosition: CountBefore}:
+//
+// "4ab2(cd)/5" => "aaaabcdcd5"
+func UnpackWith(input string, options Options) (string, error) {
+	result := strings.Builder{}
+	if _, err := UnpackStreamWith(&result, strings.NewReader(input), options); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// isDigit reports whether r is a repeat number digit
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
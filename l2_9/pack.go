@@ -0,0 +1,114 @@
+package l2_9
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidPackString is an error that describes a case when the input can't be packed without losing data
+var ErrInvalidPackString = errors.New("invalid pack string")
+
+// Pack converts string to format (.[0-9]+)+, it's the inverse of Unpack: Unpack(Pack(s)) == s
+//
+// every run of 2 or more equal runes is written with a repeat number,
+// digits, backslashes and parentheses are escaped with a backslash
+//
+// Example:
+//
+// "aaaabccddddde" => "a4bc2d5e"
+//
+// "qwe45" => "qwe\4\5"
+//
+// "" => ""
+func Pack(input string) (string, error) {
+	return PackWith(input, Options{})
+}
+
+// PackShortest is Pack that writes a run as repeated runes when it takes no more bytes than a repeat number
+//
+// Example:
+//
+// "aabccc" => "aabc3"
+//
+// "44" => "\42"
+func PackShortest(input string) (string, error) {
+	return PackWith(input, Options{Shortest: true})
+}
+
+// PackWith is Pack in the dialect of options, UnpackWith(PackWith(s, options), options) == s
+//
+// it uses Escape, CountPosition, MaxRepeat and Shortest, the rest only matters for unpacking
+func PackWith(input string, options Options) (string, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return "", err
+	}
+
+	// []rune would silently turn broken bytes into U+FFFD and Unpack couldn't restore them
+	if !utf8.ValidString(input) {
+		return "", fmt.Errorf("%w: input is not valid UTF-8", ErrInvalidPackString)
+	}
+
+	result := strings.Builder{}
+	units := splitUnits(input, options.Graphemes)
+
+	for i := 0; i < len(units); {
+		// find the run of equal runes (grapheme clusters)
+		//
+		// aaaab  run of 'a' is 4 long
+		// ^   ^
+		runLength := 1
+		for i+runLength < len(units) && units[i+runLength] == units[i] {
+			runLength++
+		}
+
+		// runs longer than MaxRepeat are split: a5a2
+		symbol, single := encodeUnit(units[i], options.Escape)
+		for remaining := runLength; remaining > 0; {
+			length := remaining
+			if options.MaxRepeat > 0 {
+				length = int(min(int64(length), options.MaxRepeat))
+			}
+			packRun(&result, symbol, single, length, options)
+			remaining -= length
+		}
+
+		i += runLength
+	}
+
+	return result.String(), nil
+}
+
+// packRun writes symbol repeated length times, a symbol that isn't single is repeated as a group
+func packRun(result *strings.Builder, symbol string, single bool, length int, options Options) {
+	count := strconv.Itoa(length)
+	repeated := symbol
+	if !single {
+		repeated = "(" + symbol + ")"
+	}
+
+	switch {
+	case length == 1:
+		result.WriteString(symbol)
+	case options.Shortest && len(symbol)*length <= len(repeated)+len(count):
+		// "aa" is as short as "a2" and easier to read
+		result.WriteString(strings.Repeat(symbol, length))
+	case options.CountPosition == CountBefore:
+		result.WriteString(count)
+		result.WriteString(repeated)
+	default:
+		result.WriteString(repeated)
+		result.WriteString(count)
+	}
+}
+
+// packSymbol returns the rune as Unpack expects it: digits, the escape and parentheses are escaped
+func packSymbol(r rune, escape rune) string {
+	if isSyntax(r, escape) {
+		return string(escape) + string(r)
+	}
+	return string(r)
+}
@@ -0,0 +1,168 @@
+package l2_9
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PackBits packet limits
+const (
+	// maxPackBitsPacket is the longest literal or repeat packet
+	maxPackBitsPacket = 128
+	// minPackBitsRun is the shortest run worth a repeat packet, shorter ones stay in literals
+	minPackBitsRun = 3
+	// packBitsNoOp is the header decoders skip
+	packBitsNoOp = -128
+)
+
+// PackBits is Apple's PackBits encoding (TIFF compression 32773) of packets with a signed header byte n:
+// 0..127 is followed by n+1 literal bytes, -1..-127 by one byte repeated 1-n times, -128 is skipped
+//
+// Example:
+//
+// "aaaabc" => FD 61 01 62 63
+type PackBits struct {
+	// MaxOutput limits the decoded output in bytes, 0 means no limit
+	MaxOutput int64
+}
+
+// Encode encodes src into packets
+func (c PackBits) Encode(src []byte) ([]byte, error) {
+	return encodeBytes(c, src)
+}
+
+// Decode decodes packets
+func (c PackBits) Decode(src []byte) ([]byte, error) {
+	return decodeBytes(c, src)
+}
+
+// EncodeStream encodes src into packets as it goes
+func (c PackBits) EncodeStream(dst io.Writer, src io.Reader) (int64, error) {
+	in := bufio.NewReader(src)
+	out := bufio.NewWriter(dst)
+	e := packBitsEncoder{w: byteWriter{out: out}}
+
+	for {
+		b, err := in.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return e.w.written, fmt.Errorf("%w: failed to read input: %w", ErrInternalUnpackingError, err)
+		}
+		if err = e.add(b); err != nil {
+			return e.w.written, err
+		}
+	}
+
+	if err := e.endRun(); err != nil {
+		return e.w.written, err
+	}
+	if err := e.flushLiteral(); err != nil {
+		return e.w.written, err
+	}
+	return e.w.written, flushOutput(out)
+}
+
+// packBitsEncoder collects the current run and the literal before it
+type packBitsEncoder struct {
+	w       byteWriter
+	literal []byte
+	current byte
+	count   int
+}
+
+// add appends a byte to the current run or ends it
+func (e *packBitsEncoder) add(b byte) error {
+	if e.count > 0 && b == e.current && e.count < maxPackBitsPacket {
+		e.count++
+		return nil
+	}
+	if err := e.endRun(); err != nil {
+		return err
+	}
+	e.current = b
+	e.count = 1
+	return nil
+}
+
+// endRun writes a long run as a repeat packet, a short one goes to the literal
+func (e *packBitsEncoder) endRun() error {
+	if e.count == 0 {
+		return nil
+	}
+	count := e.count
+	e.count = 0
+
+	if count >= minPackBitsRun {
+		if err := e.flushLiteral(); err != nil {
+			return err
+		}
+		return e.w.write([]byte{byte(int8(1 - count)), e.current}, 0)
+	}
+
+	for ; count > 0; count-- {
+		e.literal = append(e.literal, e.current)
+	}
+	if len(e.literal) >= maxPackBitsPacket {
+		if err := e.w.write(append([]byte{maxPackBitsPacket - 1}, e.literal[:maxPackBitsPacket]...), 0); err != nil {
+			return err
+		}
+		e.literal = append(e.literal[:0], e.literal[maxPackBitsPacket:]...)
+	}
+	return nil
+}
+
+// flushLiteral writes the collected literal bytes as one packet
+func (e *packBitsEncoder) flushLiteral() error {
+	if len(e.literal) == 0 {
+		return nil
+	}
+	err := e.w.write(append([]byte{byte(len(e.literal) - 1)}, e.literal...), 0)
+	e.literal = e.literal[:0]
+	return err
+}
+
+// DecodeStream decodes packets as it goes
+func (c PackBits) DecodeStream(dst io.Writer, src io.Reader) (int64, error) {
+	in := bufio.NewReader(src)
+	out := bufio.NewWriter(dst)
+	w := byteWriter{out: out, maxOutput: c.MaxOutput}
+	literal := make([]byte, maxPackBitsPacket)
+
+	for offset := 0; ; {
+		header, err := in.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return w.written, readError(err, "a packet")
+		}
+
+		switch n := int(int8(header)); {
+		case n == packBitsNoOp:
+			offset++
+		case n >= 0:
+			if _, err = io.ReadFull(in, literal[:n+1]); err != nil {
+				return w.written, readError(err, "a literal packet")
+			}
+			if err = w.write(literal[:n+1], offset); err != nil {
+				return w.written, err
+			}
+			offset += n + 2
+		default:
+			b, err := in.ReadByte()
+			if err != nil {
+				return w.written, readError(err, "a repeat packet")
+			}
+			if err = w.writeRepeated(b, 1-n, offset); err != nil {
+				return w.written, err
+			}
+			offset += 2
+		}
+	}
+
+	return w.written, flushOutput(out)
+}
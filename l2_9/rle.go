@@ -0,0 +1,101 @@
+package l2_9
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxByteRun is the longest run of one ByteRLE pair
+const maxByteRun = 255
+
+// ByteRLE is the simplest binary run-length encoding: pairs of a count 1-255 and a byte
+//
+// Example:
+//
+// "aaaab" => 04 61 01 62
+type ByteRLE struct {
+	// MaxOutput limits the decoded output in bytes, 0 means no limit
+	MaxOutput int64
+}
+
+// Encode encodes src into pairs
+func (c ByteRLE) Encode(src []byte) ([]byte, error) {
+	return encodeBytes(c, src)
+}
+
+// Decode decodes pairs
+func (c ByteRLE) Decode(src []byte) ([]byte, error) {
+	return decodeBytes(c, src)
+}
+
+// EncodeStream encodes src into pairs as it goes
+func (c ByteRLE) EncodeStream(dst io.Writer, src io.Reader) (int64, error) {
+	in := bufio.NewReader(src)
+	out := bufio.NewWriter(dst)
+	w := byteWriter{out: out}
+
+	var current byte
+	count := 0
+	for {
+		b, err := in.ReadByte()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return w.written, fmt.Errorf("%w: failed to read input: %w", ErrInternalUnpackingError, err)
+		}
+
+		// the run ends on another byte, at the maximal length or at the end
+		if count > 0 && (err != nil || b != current || count == maxByteRun) {
+			if writeErr := w.write([]byte{byte(count), current}, 0); writeErr != nil {
+				return w.written, writeErr
+			}
+			count = 0
+		}
+		if err != nil {
+			break
+		}
+
+		current = b
+		count++
+	}
+
+	return w.written, flushOutput(out)
+}
+
+// DecodeStream decodes pairs as it goes
+func (c ByteRLE) DecodeStream(dst io.Writer, src io.Reader) (int64, error) {
+	in := bufio.NewReader(src)
+	out := bufio.NewWriter(dst)
+	w := byteWriter{out: out, maxOutput: c.MaxOutput}
+
+	for offset := 0; ; offset += 2 {
+		count, err := in.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return w.written, readError(err, "a pair")
+		}
+		b, err := in.ReadByte()
+		if err != nil {
+			return w.written, readError(err, "a pair")
+		}
+		if count == 0 {
+			return w.written, fmt.Errorf("%w: zero count at offset %d", ErrCorruptData, offset)
+		}
+
+		if err = w.writeRepeated(b, int(count), offset); err != nil {
+			return w.written, err
+		}
+	}
+
+	return w.written, flushOutput(out)
+}
+
+// flushOutput flushes the buffered output of a stream
+func flushOutput(out *bufio.Writer) error {
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("%w: failed to write output: %w", ErrInternalUnpackingError, err)
+	}
+	return nil
+}
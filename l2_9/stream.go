@@ -0,0 +1,375 @@
+package l2_9
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// ErrOutputLimit is an error that describes a case when unpacked output would be larger than allowed
+var ErrOutputLimit = errors.New("unpacked output exceeds the limit")
+
+// OutputLimitError is returned by UnpackStream when the output would exceed maxOutput (Options.MaxOutput),
+// errors.Is(err, ErrOutputLimit) is true for it
+type OutputLimitError struct {
+	// Limit is the maxOutput in bytes
+	Limit int64
+	// Index is the rune index of the repeated symbol that hit the limit
+	Index int
+}
+
+// Error implements error
+func (e *OutputLimitError) Error() string {
+	return fmt.Sprintf("%v: more than %d bytes, index %d", ErrOutputLimit, e.Limit, e.Index)
+}
+
+// Unwrap lets errors.Is match ErrOutputLimit
+func (e *OutputLimitError) Unwrap() error {
+	return ErrOutputLimit
+}
+
+// repeatChunkSize is how many bytes of a repeated rune are written at once
+const repeatChunkSize = 4096
+
+// MaxGroupDepth is how deep groups may be nested: "((a)2)3" is 2 deep
+const MaxGroupDepth = 64
+
+// UnpackStream is Unpack that reads the packed string from src and writes the result to dst as it goes,
+// so neither the input nor the output has to fit in memory
+//
+// maxOutput limits the output in bytes, 0 or less means no limit. A repeat number that would exceed it
+// fails with *OutputLimitError before anything of it is written. Groups are kept in memory until their
+// repeat number is known, their content counts toward the limit from the start, so the limit bounds memory too.
+// It returns the number of bytes written, on error dst may already have a part of the output
+func UnpackStream(dst io.Writer, src io.Reader, maxOutput int64) (int64, error) {
+	return UnpackStreamWith(dst, src, Options{MaxOutput: max(maxOutput, 0)})
+}
+
+// UnpackStreamWith is UnpackStream in the dialect of options, the limit is options.MaxOutput
+func UnpackStreamWith(dst io.Writer, src io.Reader, options Options) (int64, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+
+	u := unpacker{
+		in:      bufio.NewReader(src),
+		out:     bufio.NewWriter(dst),
+		options: options,
+	}
+	err = u.run()
+	if flushErr := u.out.Flush(); flushErr != nil && err == nil {
+		err = fmt.Errorf("%w: failed to write output: %w", ErrInternalUnpackingError, flushErr)
+	}
+	return u.written, err
+}
+
+// unpacker is the state of one UnpackStream
+type unpacker struct {
+	in      *bufio.Reader
+	out     *bufio.Writer
+	options Options
+	written int64
+
+	// index and offset are the rune index and the byte offset of the current rune
+	index  int
+	offset int64
+	// symbol is the encoded rune or the group content to print, it's only valid when symbolSet is true
+	symbol      []byte
+	symbolIndex int
+	symbolSet   bool
+	// symbolOpen is true while the next runes may extend the grapheme cluster of the symbol
+	symbolOpen bool
+	// groups are the open groups, innermost last: their content is collected until the repeat number
+	groups []unpackGroup
+	// repeat is the repeat number read so far, it's only valid when repeatSet is true
+	repeat unpackRepeat
+	// escaping is true right after the escape rune, escapeIndex and escapeOffset are where it is
+	escaping     bool
+	escapeIndex  int
+	escapeOffset int64
+}
+
+// unpackRepeat is a repeat number and where it begins
+type unpackRepeat struct {
+	value  int64
+	set    bool
+	index  int
+	offset int64
+}
+
+// unpackGroup is an open group: "(ab" of "(ab)3"
+type unpackGroup struct {
+	content bytes.Buffer
+	// index and offset are where the opening parenthesis is
+	index  int
+	offset int64
+	// repeat is the repeat number before the group in CountBefore dialects
+	repeat unpackRepeat
+}
+
+func (u *unpacker) run() error {
+	/*
+		The algorithm:
+		1) iterate through every character
+		2) if encountered a number then use it as repeat count
+		3) finished the number or there was no number - print the previously read character
+		4) after iterating print if any
+
+		escaping:
+		1) encountered \ - let the program know we're now "escaping"
+		2) escaping - just set current char as the printed one and set escaping=false
+
+		states:
+		1) saw \, moving on
+		2) saw something after \, set as current char
+		3) see numbers, interpret as repeat counter digits
+		4) see any else char, print current char * current counter times
+
+		with CountBefore the number is read first and the char is printed as soon as it's seen
+	*/
+
+	for {
+		r, size, err := u.in.ReadRune()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to read input: %w", ErrInternalUnpackingError, err)
+		}
+
+		if err = u.next(r); err != nil {
+			return err
+		}
+		u.index++
+		u.offset += int64(size)
+	}
+
+	if u.escaping {
+		return newUnpackError(ReasonTrailingEscape, u.escapeIndex, u.escapeOffset, u.options.Escape)
+	}
+	if len(u.groups) > 0 {
+		group := u.groups[len(u.groups)-1]
+		return newUnpackError(ReasonUnclosedGroup, group.index, group.offset, '(')
+	}
+	if u.repeat.set && !u.symbolSet && u.options.CountPosition == CountBefore {
+		return newUnpackError(ReasonDanglingRepeat, u.repeat.index, u.repeat.offset, u.firstDigit())
+	}
+	return u.flush()
+}
+
+// next handles one rune of the input
+func (u *unpacker) next(r rune) error {
+	// grapheme clusters take in runes until a boundary
+	//
+	// e´´3  'e' + 2 combining accents (shown as ´) are the current symbol, '3' ends it
+	//    ^
+	if u.symbolOpen {
+		if continuesCluster(u.symbol, r, u.options.Escape) {
+			u.symbol = utf8.AppendRune(u.symbol, r)
+			return nil
+		}
+		if err := u.closeSymbol(); err != nil {
+			return err
+		}
+	}
+
+	// abc\45 => current symbol is 5, no more actions: no repeat counting, no prev char print
+	//     ^
+	if u.escaping {
+		u.escaping = false
+		return u.setSymbol(utf8.AppendRune(nil, r), u.index, true)
+	}
+
+	// begin/continue writing repeat counter
+	//
+	// a123dv  repeat=1*10 + 2 = 12
+	//   ^
+	// a123dv  repeat=12*10 + 3 = 123
+	//    ^
+	if isDigit(r) {
+		return u.addDigit(r)
+	}
+
+	// else we just write the previous symbol
+
+	// abc123d4  write 'c' 123x
+	//       ^
+	if err := u.flush(); err != nil {
+		return err
+	}
+
+	switch r {
+	// begin escaping
+	case u.options.Escape:
+		u.escaping = true
+		u.escapeIndex, u.escapeOffset = u.index, u.offset
+		return nil
+
+	// begin a group: its content is collected until ')'
+	//
+	// x(ab)3  group content 'ab' is the current symbol
+	//  ^
+	case '(':
+		if len(u.groups) >= u.options.MaxGroupDepth {
+			err := newUnpackError(ReasonGroupTooDeep, u.index, u.offset, r)
+			err.Expected = fmt.Sprintf("at most %d nested groups", u.options.MaxGroupDepth)
+			return err
+		}
+		// 3(ab) => the group keeps its repeat number until ')'
+		u.groups = append(u.groups, unpackGroup{index: u.index, offset: u.offset, repeat: u.repeat})
+		u.repeat = unpackRepeat{}
+		return nil
+
+	// end a group: it becomes the current symbol, a repeat number may follow
+	//
+	// x(ab)3  group content 'ab' is the current symbol
+	//     ^
+	case ')':
+		if len(u.groups) == 0 {
+			err := newUnpackError(ReasonUnbalancedGroup, u.index, u.offset, r)
+			err.Expected = fmt.Sprintf("'(' before it or an escaped '%c)'", u.options.Escape)
+			return err
+		}
+		if u.repeat.set {
+			// (a3) with CountBefore: nothing to repeat 3 times
+			return newUnpackError(ReasonDanglingRepeat, u.repeat.index, u.repeat.offset, u.firstDigit())
+		}
+		group := u.groups[len(u.groups)-1]
+		u.groups = u.groups[:len(u.groups)-1]
+
+		// the content is counted again when it's written repeat times
+		u.written -= int64(group.content.Len())
+		u.repeat = group.repeat
+		return u.setSymbol(group.content.Bytes(), group.index, false)
+	}
+
+	return u.setSymbol(utf8.AppendRune(nil, r), u.index, true)
+}
+
+// setSymbol makes symbol the current one, with CountBefore it's written as soon as it's complete
+//
+// a rune symbol stays open in Graphemes mode: the next runes may belong to its grapheme cluster
+func (u *unpacker) setSymbol(symbol []byte, index int, isRune bool) error {
+	u.symbol = symbol
+	u.symbolIndex = index
+	u.symbolSet = true
+
+	if isRune && u.options.Graphemes {
+		u.symbolOpen = true
+		return nil
+	}
+	return u.closeSymbol()
+}
+
+// closeSymbol completes the current symbol, with CountBefore it's written at once
+func (u *unpacker) closeSymbol() error {
+	u.symbolOpen = false
+	if u.options.CountPosition == CountBefore {
+		return u.flush()
+	}
+	return nil
+}
+
+// addDigit appends a digit to the repeat number
+func (u *unpacker) addDigit(r rune) error {
+	if !u.symbolSet && u.options.CountPosition == CountAfter {
+		return newUnpackError(ReasonRepeatWithoutSymbol, u.index, u.offset, r)
+	}
+	// value*10 + digit must stay within int64
+	if u.repeat.value > (math.MaxInt64-int64(r-'0'))/10 {
+		return newUnpackError(ReasonRepeatTooLarge, u.index, u.offset, r)
+	}
+
+	if !u.repeat.set {
+		u.repeat = unpackRepeat{set: true, index: u.index, offset: u.offset}
+	}
+	u.repeat.value = u.repeat.value*10 + int64(r-'0')
+
+	if u.options.MaxRepeat > 0 && u.repeat.value > u.options.MaxRepeat {
+		err := newUnpackError(ReasonRepeatTooLarge, u.index, u.offset, r)
+		err.Expected = fmt.Sprintf("a repeat number of at most %d", u.options.MaxRepeat)
+		return err
+	}
+
+	// a999999999 fails here instead of writing gigabytes first
+	if u.symbolSet {
+		return u.checkLimit(u.repeat.value)
+	}
+	return nil
+}
+
+// firstDigit returns the first digit of the current repeat number for errors
+func (u *unpacker) firstDigit() rune {
+	value := u.repeat.value
+	for value >= 10 {
+		value /= 10
+	}
+	return '0' + rune(value)
+}
+
+// flush writes the current symbol repeat times (once if there's no repeat number) and forgets it
+func (u *unpacker) flush() error {
+	if !u.symbolSet {
+		return nil
+	}
+
+	count := int64(1)
+	if u.repeat.set {
+		count = u.repeat.value
+		if count == 0 && u.options.RejectZero {
+			return newUnpackError(ReasonZeroRepeat, u.repeat.index, u.repeat.offset, '0')
+		}
+	}
+	if err := u.checkLimit(count); err != nil {
+		return err
+	}
+	if err := u.writeRepeated(u.symbol, count); err != nil {
+		return err
+	}
+
+	u.symbolSet = false
+	u.repeat = unpackRepeat{}
+	return nil
+}
+
+// checkLimit fails if writing the current symbol count times would exceed the output limit
+func (u *unpacker) checkLimit(count int64) error {
+	if u.options.MaxOutput <= 0 || len(u.symbol) == 0 {
+		return nil
+	}
+	if count > (u.options.MaxOutput-u.written)/int64(len(u.symbol)) {
+		return &OutputLimitError{Limit: u.options.MaxOutput, Index: u.symbolIndex}
+	}
+	return nil
+}
+
+// writeRepeated writes symbol count times in chunks to the innermost open group or to the output
+func (u *unpacker) writeRepeated(symbol []byte, count int64) error {
+	if len(symbol) == 0 {
+		return nil
+	}
+
+	var out io.Writer = u.out
+	if len(u.groups) > 0 {
+		out = &u.groups[len(u.groups)-1].content
+	}
+
+	perChunk := max(1, min(count, int64(repeatChunkSize/len(symbol))))
+	chunk := bytes.Repeat(symbol, int(perChunk))
+
+	for count > 0 {
+		n := min(count, perChunk)
+		written, err := out.Write(chunk[:n*int64(len(symbol))])
+		u.written += int64(written)
+		if err != nil {
+			return fmt.Errorf("%w: failed to write output: %w", ErrInternalUnpackingError, err)
+		}
+		count -= n
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package l2_9
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 type testCase struct {
@@ -61,3 +65,569 @@ func TestEscaping(t *testing.T) {
 func TestComplex(t *testing.T) {
 	runTests(t, complexStrings)
 }
+
+type packTestCase struct {
+	input            string
+	expectedOutput   string
+	expectedShortest string
+}
+
+var packStrings = []packTestCase{
+	{"", "", ""},
+	{"abcd", "abcd", "abcd"},
+	{"aaaabccddddde", "a4bc2d5e", "a4bccd5e"},
+	{"qwe45", "qwe\\4\\5", "qwe\\4\\5"},
+	{"qwe44444", "qwe\\45", "qwe\\45"},
+	{"44", "\\42", "\\42"},
+	{"\\\\\\", "\\\\3", "\\\\3"},
+	{"aaaaaaaaaaaa", "a12", "a12"},
+	{"ééé日日", "é3日2", "é3日2"},
+	{"x日日", "x日2", "x日2"},
+	{"aaébb", "a2éb2", "aaébb"},
+}
+
+func TestPack(t *testing.T) {
+	for _, v := range packStrings {
+		result, err := Pack(v.input)
+		if err != nil {
+			t.Errorf("unexpected error (input: %s): %v", v.input, err)
+			continue
+		}
+		if result != v.expectedOutput {
+			t.Errorf("unexpected result:\t%s\t(expected: %s, input: %s)", result, v.expectedOutput, v.input)
+		}
+
+		result, err = PackShortest(v.input)
+		if err != nil {
+			t.Errorf("unexpected error (input: %s): %v", v.input, err)
+			continue
+		}
+		if result != v.expectedShortest {
+			t.Errorf("unexpected shortest result:\t%s\t(expected: %s, input: %s)", result, v.expectedShortest, v.input)
+		}
+	}
+}
+
+func TestPackInvalidUTF8(t *testing.T) {
+	if _, err := Pack("a\xffb"); !errors.Is(err, ErrInvalidPackString) {
+		t.Errorf("expected ErrInvalidPackString, got %v", err)
+	}
+}
+
+func FuzzPackUnpack(f *testing.F) {
+	for _, v := range packStrings {
+		f.Add(v.input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		if !utf8.ValidString(input) {
+			return
+		}
+		for _, pack := range []func(string) (string, error){Pack, PackShortest} {
+			packed, err := pack(input)
+			if err != nil {
+				t.Fatalf("pack %q: %v", input, err)
+			}
+			unpacked, err := Unpack(packed)
+			if err != nil {
+				t.Fatalf("unpack %q (packed from %q): %v", packed, input, err)
+			}
+			if unpacked != input {
+				t.Fatalf("round trip of %q gave %q (packed %q)", input, unpacked, packed)
+			}
+		}
+	})
+}
+
+func TestUnpackStream(t *testing.T) {
+	for _, cases := range [][]testCase{basicStrings, escapingStrings, complexStrings} {
+		for _, v := range cases {
+			var out strings.Builder
+			written, err := UnpackStream(&out, strings.NewReader(v.input), 0)
+			if (err != nil) != v.expectsError {
+				t.Errorf("unexpected error state (input: %s): %v", v.input, err)
+				continue
+			}
+			if err == nil && (out.String() != v.expectedOutput || written != int64(len(v.expectedOutput))) {
+				t.Errorf("unexpected result:\t%s, %d bytes\t(expected: %s, input: %s)",
+					out.String(), written, v.expectedOutput, v.input)
+			}
+		}
+	}
+
+	// long runs are written in chunks
+	written, err := UnpackStream(io.Discard, strings.NewReader("a100000б3"), 0)
+	if err != nil || written != 100006 {
+		t.Errorf("unexpected result: %d bytes, %v", written, err)
+	}
+}
+
+func TestUnpackStreamLimit(t *testing.T) {
+	var out strings.Builder
+	written, err := UnpackStream(&out, strings.NewReader("a4bc2d5e"), 13)
+	if err != nil || out.String() != "aaaabccddddde" || written != 13 {
+		t.Errorf("output of exactly the limit must pass: %q, %v", out.String(), err)
+	}
+
+	_, err = UnpackStream(io.Discard, strings.NewReader("a4bc2d5e"), 12)
+	var limitErr *OutputLimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrOutputLimit) {
+		t.Fatalf("expected OutputLimitError, got %v", err)
+	}
+	if limitErr.Limit != 12 || limitErr.Index != 7 {
+		t.Errorf("unexpected limit error %+v", limitErr)
+	}
+
+	// the repeat number fails as soon as it's too large, nothing is written for it
+	out.Reset()
+	_, err = UnpackStream(&out, strings.NewReader("xa999999999"), 1024)
+	if !errors.As(err, &limitErr) || limitErr.Index != 1 || out.String() != "x" {
+		t.Errorf("unexpected result: %q, %v", out.String(), err)
+	}
+
+	_, err = UnpackStream(io.Discard, strings.NewReader("a99999999999999999999"), 0)
+	if !errors.Is(err, ErrInvalidUnpackString) {
+		t.Errorf("expected ErrInvalidUnpackString for an overflowing repeat number, got %v", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("disk is on fire")
+}
+
+func TestUnpackStreamReadError(t *testing.T) {
+	_, err := UnpackStream(io.Discard, failingReader{}, 0)
+	if !errors.Is(err, ErrInternalUnpackingError) {
+		t.Errorf("expected ErrInternalUnpackingError, got %v", err)
+	}
+}
+
+type unpackErrorTestCase struct {
+	input  string
+	reason UnpackErrorReason
+	index  int
+	offset int64
+	r      rune
+}
+
+var unpackErrorStrings = []unpackErrorTestCase{
+	{"45", ReasonRepeatWithoutSymbol, 0, 0, '4'},
+	{"ab2\\", ReasonTrailingEscape, 3, 3, '\\'},
+	{"éé\\", ReasonTrailingEscape, 2, 4, '\\'},
+	{"日99999999999999999999", ReasonRepeatTooLarge, 19, 21, '9'},
+	{"a9223372036854775808", ReasonRepeatTooLarge, 19, 19, '8'},
+	{"a92233720368547758090", ReasonRepeatTooLarge, 19, 19, '9'},
+}
+
+func TestUnpackError(t *testing.T) {
+	for _, v := range unpackErrorStrings {
+		_, err := Unpack(v.input)
+
+		var unpackErr *UnpackError
+		if !errors.As(err, &unpackErr) {
+			t.Errorf("expected UnpackError (input: %s), got %v", v.input, err)
+			continue
+		}
+		if !errors.Is(err, ErrInvalidUnpackString) {
+			t.Errorf("UnpackError must match ErrInvalidUnpackString (input: %s)", v.input)
+		}
+		if unpackErr.Reason != v.reason || unpackErr.Index != v.index || unpackErr.Offset != v.offset ||
+			unpackErr.Rune != v.r || unpackErr.Expected == "" {
+			t.Errorf("unexpected error %+v (input: %s)", unpackErr, v.input)
+		}
+	}
+}
+
+func TestUnpackErrorDiagnostic(t *testing.T) {
+	input := "\té3 a\\"
+	_, err := Unpack(input)
+
+	var unpackErr *UnpackError
+	if !errors.As(err, &unpackErr) {
+		t.Fatalf("expected UnpackError, got %v", err)
+	}
+	expected := "\té3 a\\\n\t    ^ mustn't end with escaping: expected a rune after the escape"
+	if diagnostic := unpackErr.Diagnostic(input); diagnostic != expected {
+		t.Errorf("unexpected diagnostic:\n%s\nexpected:\n%s", diagnostic, expected)
+	}
+}
+
+func TestUnpackErrorDiagnosticMultiline(t *testing.T) {
+	input := "a2\nb3\n\tc)\nd"
+	_, err := UnpackStream(io.Discard, strings.NewReader(input), 0)
+
+	var unpackErr *UnpackError
+	if !errors.As(err, &unpackErr) {
+		t.Fatalf("expected UnpackError, got %v", err)
+	}
+	expected := "\tc)\n\t ^ group is closed but not opened: expected '(' before it or an escaped '\\)'"
+	if diagnostic := unpackErr.Diagnostic(input); diagnostic != expected {
+		t.Errorf("unexpected diagnostic:\n%s\nexpected:\n%s", diagnostic, expected)
+	}
+}
+
+var groupStrings = []testCase{
+	{"(ab)3", "ababab", false},
+	{"x(ab)3y", "xabababy", false},
+	{"((ab)2c)2", "ababcababc", false},
+	{"(a2b)2c", "aabaabc", false},
+	{"(ab)", "ab", false},
+	{"(ab)0c", "c", false},
+	{"()3", "", false},
+	{"(日é)2", "日é日é", false},
+	{"\\(a\\)2", "(a))", false},
+	{"(\\)2)2", "))))", false},
+	{"(ab)12", "abababababababababababab", false},
+	{"(ab", "", true},
+	{"ab)", "", true},
+	{"(2)", "", true},
+	{"((a)", "", true},
+}
+
+func TestGroups(t *testing.T) {
+	runTests(t, groupStrings)
+}
+
+func TestGroupErrors(t *testing.T) {
+	for _, v := range []unpackErrorTestCase{
+		{"a(b(c)2", ReasonUnclosedGroup, 1, 1, '('},
+		{"ab)2", ReasonUnbalancedGroup, 2, 2, ')'},
+		{"(2)", ReasonRepeatWithoutSymbol, 1, 1, '2'},
+		{strings.Repeat("(", MaxGroupDepth+1), ReasonGroupTooDeep, MaxGroupDepth, MaxGroupDepth, '('},
+	} {
+		_, err := Unpack(v.input)
+
+		var unpackErr *UnpackError
+		if !errors.As(err, &unpackErr) {
+			t.Errorf("expected UnpackError (input: %s), got %v", v.input, err)
+			continue
+		}
+		if unpackErr.Reason != v.reason || unpackErr.Index != v.index || unpackErr.Offset != v.offset ||
+			unpackErr.Rune != v.r {
+			t.Errorf("unexpected error %+v (input: %s)", unpackErr, v.input)
+		}
+	}
+
+	deepest := strings.Repeat("(", MaxGroupDepth) + "a" + strings.Repeat(")", MaxGroupDepth)
+	if result, err := Unpack(deepest); err != nil || result != "a" {
+		t.Errorf("groups of the maximal depth must pass: %q, %v", result, err)
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	var limitErr *OutputLimitError
+
+	// the repeat number of the group is checked against the whole group
+	_, err := UnpackStream(io.Discard, strings.NewReader("x(abc)4"), 12)
+	if !errors.As(err, &limitErr) || limitErr.Index != 1 {
+		t.Errorf("expected OutputLimitError at the group, got %v", err)
+	}
+	if written, err := UnpackStream(io.Discard, strings.NewReader("x(abc)3"), 12); err != nil || written != 10 {
+		t.Errorf("unexpected result: %d bytes, %v", written, err)
+	}
+
+	// nested groups multiply
+	_, err = UnpackStream(io.Discard, strings.NewReader("((a1000)1000)1000"), 1<<20)
+	if !errors.As(err, &limitErr) {
+		t.Errorf("expected OutputLimitError, got %v", err)
+	}
+}
+
+func TestPackParentheses(t *testing.T) {
+	result, err := Pack("((ab))")
+	if err != nil || result != "\\(2ab\\)2" {
+		t.Errorf("unexpected result: %q, %v", result, err)
+	}
+}
+
+type optionsTestCase struct {
+	input          string
+	options        Options
+	expectedOutput string
+	expectedReason UnpackErrorReason
+}
+
+var optionsStrings = []optionsTestCase{
+	{"a4b/5/(", Options{Escape: '/'}, "aaaab5(", 0},
+	{"a\\4", Options{Escape: '/'}, "a\\\\\\\\", 0},
+	{"a4/", Options{Escape: '/'}, "", ReasonTrailingEscape},
+	{"4ab2(cd)/5", Options{Escape: '/', CountPosition: CountBefore}, "aaaabcdcd5", 0},
+	{"2(3a b)c", Options{CountPosition: CountBefore}, "aaa baaa bc", 0},
+	{"12é", Options{CountPosition: CountBefore}, "éééééééééééé", 0},
+	{"a3", Options{CountPosition: CountBefore}, "", ReasonDanglingRepeat},
+	{"(a3)", Options{CountPosition: CountBefore}, "", ReasonDanglingRepeat},
+	{"a9", Options{MaxRepeat: 9}, "aaaaaaaaa", 0},
+	{"a10", Options{MaxRepeat: 9}, "", ReasonRepeatTooLarge},
+	{"a0b", Options{}, "b", 0},
+	{"a0b", Options{RejectZero: true}, "", ReasonZeroRepeat},
+	{"0ab", Options{CountPosition: CountBefore, RejectZero: true}, "", ReasonZeroRepeat},
+	{"((a))", Options{MaxGroupDepth: 1}, "", ReasonGroupTooDeep},
+	{"(a)2", Options{MaxGroupDepth: 1}, "aa", 0},
+}
+
+func TestUnpackWith(t *testing.T) {
+	for _, v := range optionsStrings {
+		result, err := UnpackWith(v.input, v.options)
+		if v.expectedReason == 0 {
+			if err != nil || result != v.expectedOutput {
+				t.Errorf("unexpected result:\t%s, %v\t(expected: %s, input: %s, options: %+v)",
+					result, err, v.expectedOutput, v.input, v.options)
+			}
+			continue
+		}
+
+		var unpackErr *UnpackError
+		if !errors.As(err, &unpackErr) || unpackErr.Reason != v.expectedReason {
+			t.Errorf("expected %v (input: %s, options: %+v), got %v", v.expectedReason, v.input, v.options, err)
+		}
+	}
+}
+
+func TestUnpackErrorEscape(t *testing.T) {
+	for _, v := range []struct {
+		options  Options
+		expected string
+	}{
+		{Options{}, `'(' before it or an escaped '\)'`},
+		{Options{Escape: '/'}, `'(' before it or an escaped '/)'`},
+	} {
+		_, err := UnpackWith("a)", v.options)
+
+		var unpackErr *UnpackError
+		if !errors.As(err, &unpackErr) || unpackErr.Expected != v.expected {
+			t.Errorf("expected %q (options: %+v), got %v", v.expected, v.options, err)
+		}
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []Options{
+		{Escape: '5'},
+		{Escape: '('},
+		{CountPosition: 7},
+		{MaxRepeat: -1},
+	} {
+		if _, err := UnpackWith("a", options); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("unpack: expected ErrInvalidOptions for %+v, got %v", options, err)
+		}
+		if _, err := PackWith("a", options); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("pack: expected ErrInvalidOptions for %+v, got %v", options, err)
+		}
+	}
+}
+
+func TestPackWith(t *testing.T) {
+	for _, v := range []struct {
+		input    string
+		options  Options
+		expected string
+	}{
+		{"aaaab5/", Options{Escape: '/'}, "a4b/5//"},
+		{"aaaab", Options{CountPosition: CountBefore}, "4ab"},
+		{"aaaaaaa", Options{MaxRepeat: 5}, "a5a2"},
+		{"aaaaaa", Options{MaxRepeat: 5}, "a5a"},
+		{"aaaaaaabb", Options{MaxRepeat: 3, CountPosition: CountBefore, Shortest: true}, "3a3aabb"},
+	} {
+		result, err := PackWith(v.input, v.options)
+		if err != nil || result != v.expected {
+			t.Errorf("unexpected result:\t%s, %v\t(expected: %s, input: %s, options: %+v)",
+				result, err, v.expected, v.input, v.options)
+		}
+	}
+}
+
+var fuzzDialects = []Options{
+	{},
+	{Shortest: true},
+	{Escape: '/', CountPosition: CountBefore},
+	{Escape: 'é', MaxRepeat: 3, RejectZero: true},
+	{CountPosition: CountBefore, MaxRepeat: 1, Shortest: true},
+	{Graphemes: true},
+	{Graphemes: true, CountPosition: CountBefore, Escape: '/', Shortest: true},
+}
+
+func FuzzPackUnpackWith(f *testing.F) {
+	for _, v := range packStrings {
+		f.Add(v.input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		if !utf8.ValidString(input) {
+			return
+		}
+		for _, options := range fuzzDialects {
+			packed, err := PackWith(input, options)
+			if err != nil {
+				t.Fatalf("pack %q with %+v: %v", input, options, err)
+			}
+			unpacked, err := UnpackWith(packed, options)
+			if err != nil {
+				t.Fatalf("unpack %q (packed from %q) with %+v: %v", packed, input, options, err)
+			}
+			if unpacked != input {
+				t.Fatalf("round trip of %q with %+v gave %q (packed %q)", input, options, unpacked, packed)
+			}
+		}
+	})
+}
+
+var graphemeStrings = []testCase{
+	// e + combining acute
+	{"e\u03013", "e\u0301e\u0301e\u0301", false},
+	// family emoji joined by ZWJ
+	{"👨\u200d👩\u200d👧2x", "👨\u200d👩\u200d👧👨\u200d👩\u200d👧x", false},
+	// flags are pairs of regional indicators
+	{"🇺🇸🇫🇷2", "🇺🇸🇫🇷🇫🇷", false},
+	// the keycap mark can't join a repeat number, escaped digit takes it
+	{"a3\u20e3", "aaa\u20e3", false},
+	{"\\3\u20e32", "3\u20e33\u20e3", false},
+	{"(e\u0301x)2", "e\u0301xe\u0301x", false},
+	{"\r\n2", "\r\n\r\n", false},
+}
+
+func TestGraphemes(t *testing.T) {
+	for _, v := range graphemeStrings {
+		result, err := UnpackWith(v.input, Options{Graphemes: true})
+		if err != nil || result != v.expectedOutput {
+			t.Errorf("unexpected result:\t%+q, %v\t(expected: %+q, input: %+q)", result, err, v.expectedOutput, v.input)
+		}
+	}
+
+	// without the option only the accent is repeated
+	if result, _ := Unpack("e\u03013"); result != "e\u0301\u0301\u0301" {
+		t.Errorf("rune mode changed: %+q", result)
+	}
+
+	result, err := UnpackWith("3e\u0301", Options{Graphemes: true, CountPosition: CountBefore})
+	if err != nil || result != "e\u0301e\u0301e\u0301" {
+		t.Errorf("count before: unexpected result %+q, %v", result, err)
+	}
+}
+
+func TestPackGraphemes(t *testing.T) {
+	for _, v := range []struct {
+		input    string
+		expected string
+	}{
+		{"e\u0301e\u0301e\u0301", "e\u03013"},
+		{"🇺🇸🇺🇸x", "🇺🇸2x"},
+		{"3\u20e33\u20e3", "\\3\u20e32"},
+		// Arabic number sign joins the digit after it, the cluster needs a group to be repeated
+		{"\u06005\u06005", "(\u0600\\5)2"},
+		{"\u06005", "\u0600\\5"},
+	} {
+		result, err := PackWith(v.input, Options{Graphemes: true})
+		if err != nil || result != v.expected {
+			t.Errorf("unexpected result:\t%+q, %v\t(expected: %+q, input: %+q)", result, err, v.expected, v.input)
+		}
+	}
+}
+
+// codecs are the Codec implementations checked against each other
+var codecs = map[string]Codec{
+	"text":     TextCodec{},
+	"byte rle": ByteRLE{},
+	"packbits": PackBits{},
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	inputs := []string{"", "a", "aaaabccddddd", "abcdef", strings.Repeat("x", 1000), strings.Repeat("ab", 200) + strings.Repeat("c", 129)}
+	for name, codec := range codecs {
+		for _, input := range inputs {
+			encoded, err := codec.Encode([]byte(input))
+			if err != nil {
+				t.Errorf("%s: failed to encode %q: %v", name, input, err)
+				continue
+			}
+			decoded, err := codec.Decode(encoded)
+			if err != nil || string(decoded) != input {
+				t.Errorf("%s: round trip of %q gave %q, %v", name, input, decoded, err)
+			}
+		}
+	}
+}
+
+func TestByteRLE(t *testing.T) {
+	encoded, err := ByteRLE{}.Encode([]byte(strings.Repeat("a", 300) + "b"))
+	if err != nil || string(encoded) != "\xffa\x2da\x01b" {
+		t.Errorf("unexpected result: %x, %v", encoded, err)
+	}
+
+	for _, input := range []string{"\x00a", "\x02a\x01"} {
+		if _, err := (ByteRLE{}).Decode([]byte(input)); !errors.Is(err, ErrCorruptData) {
+			t.Errorf("expected ErrCorruptData for %x, got %v", input, err)
+		}
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	// the example of Apple Technical Note TN1023
+	input := []byte{0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0xAA, 0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0x22,
+		0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	expected := []byte{0xFE, 0xAA, 0x02, 0x80, 0x00, 0x2A, 0xFD, 0xAA, 0x03, 0x80, 0x00, 0x2A, 0x22, 0xF7, 0xAA}
+
+	encoded, err := PackBits{}.Encode(input)
+	if err != nil || string(encoded) != string(expected) {
+		t.Errorf("unexpected result: % X, %v (expected: % X)", encoded, err, expected)
+	}
+
+	// the no-op header is skipped
+	decoded, err := PackBits{}.Decode([]byte{0x80, 0xFE, 0x61, 0x80, 0x00, 0x62})
+	if err != nil || string(decoded) != "aaab" {
+		t.Errorf("unexpected result: %q, %v", decoded, err)
+	}
+
+	for _, input := range []string{"\x02ab", "\xfe"} {
+		if _, err := (PackBits{}).Decode([]byte(input)); !errors.Is(err, ErrCorruptData) {
+			t.Errorf("expected ErrCorruptData for %x, got %v", input, err)
+		}
+	}
+}
+
+func TestCodecLimit(t *testing.T) {
+	for name, codec := range map[string]Codec{
+		"text":     TextCodec{Options{MaxOutput: 10}},
+		"byte rle": ByteRLE{MaxOutput: 10},
+		"packbits": PackBits{MaxOutput: 10},
+	} {
+		encoded, err := codecs[name].Encode([]byte("ab" + strings.Repeat("c", 20)))
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", name, err)
+		}
+
+		_, err = codec.Decode(encoded)
+		var limitErr *OutputLimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != 10 {
+			t.Errorf("%s: expected OutputLimitError, got %v", name, err)
+		}
+	}
+}
+
+func FuzzCodecs(f *testing.F) {
+	f.Add([]byte("aaaabccddddd"))
+	f.Add([]byte{0x80, 0x80, 0x80, 0x00, 0xFF})
+	f.Fuzz(func(t *testing.T, input []byte) {
+		for name, codec := range codecs {
+			encoded, err := codec.Encode(input)
+			if name == "text" && !utf8.Valid(input) {
+				if !errors.Is(err, ErrInvalidPackString) {
+					t.Fatalf("%s: expected ErrInvalidPackString, got %v", name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: failed to encode %q: %v", name, input, err)
+			}
+
+			decoded, err := codec.Decode(encoded)
+			if err != nil || string(decoded) != string(input) {
+				t.Fatalf("%s: round trip of %q gave %q, %v", name, input, decoded, err)
+			}
+
+			// decoding arbitrary binary data must not panic, its output is at most 255 times larger
+			if name != "text" {
+				_, _ = codec.Decode(input)
+			}
+		}
+	})
+}